@@ -0,0 +1,104 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+const (
+	AnonymizationHash   = "hash"
+	AnonymizationRedact = "redact"
+
+	// redactedLabelValuePrefix is followed by the number of the redacted value within the dump.
+	redactedLabelValuePrefix = "redacted-"
+	// anonymizedHashLength is the number of hex characters kept from the hashed values.
+	anonymizedHashLength = 16
+)
+
+func isValidAnonymization(mode string) bool {
+	switch mode {
+	case "", AnonymizationHash, AnonymizationRedact:
+		return true
+	}
+	return false
+}
+
+// parseAnonymizedLabels parses the comma separated label names. The metric name can't be
+// anonymized, it's ignored if given.
+func parseAnonymizedLabels(s string) []string {
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || name == labels.MetricName {
+			continue
+		}
+		names = append(names, name)
+	}
+
+	return names
+}
+
+// newAnonymizer returns a function replacing the values of the given labels. With
+// AnonymizationHash, the values are replaced with a keyed hash. The key is generated for each
+// call and never stored, so that the same value is mapped to the same hash within a dump while
+// the original values can't be recovered by hashing well known hostnames or addresses. With
+// AnonymizationRedact, the values are replaced with numbered placeholders, e.g. redacted-1, in
+// the order they are seen. In both modes, the distinct values stay distinct so that the series
+// of different instances are not merged.
+func newAnonymizer(mode string, names []string) (func(labels.Labels) labels.Labels, error) {
+	if mode == "" || len(names) == 0 {
+		return nil, nil
+	}
+
+	var replace func(string) string
+	switch mode {
+	case AnonymizationHash:
+		key := make([]byte, sha256.Size)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("could not generate the anonymization key: %w", err)
+		}
+
+		replace = func(v string) string {
+			mac := hmac.New(sha256.New, key)
+			mac.Write([]byte(v))
+			return hex.EncodeToString(mac.Sum(nil))[:anonymizedHashLength]
+		}
+	case AnonymizationRedact:
+		var mut sync.Mutex
+		placeholders := make(map[string]string)
+		replace = func(v string) string {
+			mut.Lock()
+			defer mut.Unlock()
+
+			placeholder, ok := placeholders[v]
+			if !ok {
+				placeholder = redactedLabelValuePrefix + strconv.Itoa(len(placeholders)+1)
+				placeholders[v] = placeholder
+			}
+			return placeholder
+		}
+	default:
+		return nil, fmt.Errorf("unknown anonymization mode %q", mode)
+	}
+
+	return func(lset labels.Labels) labels.Labels {
+		b := labels.NewBuilder(lset)
+		for _, name := range names {
+			if v := lset.Get(name); v != "" {
+				b.Set(name, replace(v))
+			}
+		}
+		return b.Labels()
+	}, nil
+}
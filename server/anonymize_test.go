@@ -0,0 +1,82 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnonymizeDump(t *testing.T) {
+	maxt := time.Now().Truncate(time.Minute).UnixMilli()
+	mint := maxt - time.Hour.Milliseconds()
+
+	node1 := labels.FromStrings(labels.MetricName, "go_goroutines", "instance", "10.0.0.1:8067", "job", "prometheus")
+	node2 := labels.FromStrings(labels.MetricName, "go_goroutines", "instance", "10.0.0.2:8067", "job", "prometheus")
+	db := createTestTSDB(t, mint, maxt, node1, node2)
+
+	q, err := db.Querier(mint, maxt)
+	require.NoError(t, err)
+	defer q.Close()
+
+	rewrite := func(t *testing.T, mode string) map[string][]int64 {
+		relabel, err := newAnonymizer(mode, parseAnonymizedLabels("instance, __name__"))
+		require.NoError(t, err)
+
+		dst := t.TempDir()
+		err = rewriteTSDB(context.Background(), log.NewNopLogger(), q, dst, rewriteOptions{
+			mint:          mint,
+			maxt:          maxt,
+			blockDuration: tsdb.DefaultBlockDuration,
+			relabel:       relabel,
+		})
+		require.NoError(t, err)
+
+		return readTestSeries(t, dst)
+	}
+
+	t.Run("hash", func(t *testing.T) {
+		series := rewrite(t, AnonymizationHash)
+		require.Len(t, series, 2)
+		for lset, samples := range series {
+			require.NotContains(t, lset, "10.0.0.")
+			require.Contains(t, lset, `__name__="go_goroutines"`)
+			require.Contains(t, lset, `job="prometheus"`)
+			require.Len(t, samples, 61)
+		}
+	})
+
+	t.Run("redact", func(t *testing.T) {
+		series := rewrite(t, AnonymizationRedact)
+		// the series of the different instances are not merged
+		require.Len(t, series, 2)
+
+		for _, instance := range []string{"redacted-1", "redacted-2"} {
+			lset := labels.FromStrings(labels.MetricName, "go_goroutines", "instance", instance, "job", "prometheus")
+			require.Len(t, series[lset.String()], 61)
+		}
+	})
+
+	t.Run("redacted values are stable", func(t *testing.T) {
+		relabel, err := newAnonymizer(AnonymizationRedact, []string{"instance", "host"})
+		require.NoError(t, err)
+
+		require.Equal(t, "redacted-1", relabel(node1).Get("instance"))
+		require.Equal(t, "redacted-2", relabel(node2).Get("instance"))
+		require.Equal(t, "redacted-1", relabel(node1).Get("instance"))
+		require.Equal(t, "redacted-2", relabel(labels.FromStrings("host", "10.0.0.2:8067")).Get("host"))
+	})
+
+	t.Run("invalid mode", func(t *testing.T) {
+		require.False(t, isValidAnonymization("encrypt"))
+		_, err := newAnonymizer("encrypt", []string{"instance"})
+		require.Error(t, err)
+	})
+}
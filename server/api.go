@@ -5,9 +5,15 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 	"path/filepath"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -32,13 +38,28 @@ func newHandler(plugin *Plugin) *handler {
 
 	tsdb := root.PathPrefix("/tsdb").Subrouter()
 	tsdb.HandleFunc("/stats", handler.getStatsHandler).Methods(http.MethodGet)
+	tsdb.HandleFunc("/export", handler.exportHandler).Methods(http.MethodGet)
+
+	cluster := root.PathPrefix("/cluster").Subrouter()
+	cluster.HandleFunc("/status", handler.getClusterStatusHandler).Methods(http.MethodGet)
 
 	jobs := root.PathPrefix("/jobs").Subrouter()
 	jobs.HandleFunc("", handler.getAllJobsHandler).Methods(http.MethodGet)
 	jobs.HandleFunc("/create", handler.createJobHandler).Methods(http.MethodPost)
 	jobs.HandleFunc("/delete/{id:[A-Za-z0-9]+}", handler.deleteJobHandler).Methods(http.MethodDelete)
+	jobs.HandleFunc("/cancel/{id:[A-Za-z0-9]+}", handler.cancelJobHandler).Methods(http.MethodPost)
+	jobs.HandleFunc("/retry/{id:[A-Za-z0-9]+}", handler.retryJobHandler).Methods(http.MethodPost)
 	jobs.HandleFunc("/deleteAll", handler.deleteAllJobsHandler).Methods(http.MethodDelete)
 	jobs.HandleFunc("/download/{id:[A-Za-z0-9]+}", handler.downloadJobHandler).Methods(http.MethodGet)
+	jobs.HandleFunc("/verify/{id:[A-Za-z0-9]+}", handler.verifyJobHandler).Methods(http.MethodGet)
+
+	imports := root.PathPrefix("/imports").Subrouter()
+	imports.HandleFunc("/upload", handler.uploadImportHandler).Methods(http.MethodPost)
+
+	schedules := root.PathPrefix("/schedules").Subrouter()
+	schedules.HandleFunc("", handler.getAllSchedulesHandler).Methods(http.MethodGet)
+	schedules.HandleFunc("/create", handler.createScheduleHandler).Methods(http.MethodPost)
+	schedules.HandleFunc("/delete/{id:[A-Za-z0-9]+}", handler.deleteScheduleHandler).Methods(http.MethodDelete)
 
 	handler.router = root
 
@@ -69,6 +90,14 @@ func (h *handler) authorized(next http.Handler) http.Handler {
 type JobCreateRequest struct {
 	MinT int64 `json:"min_t"`
 	MaxT int64 `json:"max_t"`
+	// Matchers are optional series selectors, e.g. `{job="calls"}` or `{__name__=~"go_.*"}`.
+	Matchers []string `json:"matchers"`
+	// Format is the format of the dump contents, "tsdb" (default) or "openmetrics".
+	Format string `json:"format"`
+	// Anonymization is optional, "hash" or "redact" to anonymize the configured labels.
+	Anonymization string `json:"anonymization"`
+	// Delivery is optional, the dump is delivered to the target once it's created.
+	Delivery *DumpDelivery `json:"delivery"`
 }
 
 func (h *handler) createJobHandler(w http.ResponseWriter, r *http.Request) {
@@ -80,7 +109,40 @@ func (h *handler) createJobHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	job, err := h.plugin.CreateJob(r.Context(), jcr.MinT, jcr.MaxT)
+	if _, err = parseMatcherSets(jcr.Matchers); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !isValidDumpFormat(jcr.Format) {
+		http.Error(w, "unknown dump format", http.StatusBadRequest)
+		return
+	}
+
+	if !isValidAnonymization(jcr.Anonymization) {
+		http.Error(w, "unknown anonymization mode", http.StatusBadRequest)
+		return
+	}
+
+	userID := r.Header.Get("Mattermost-User-Id")
+	if jcr.Delivery != nil {
+		if err = h.plugin.validateDelivery(jcr.Delivery, userID); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		jcr.Delivery.DeliveredAt = 0
+		jcr.Delivery.Error = ""
+	}
+
+	job, err := h.plugin.CreateJob(r.Context(), &DumpJob{
+		MinT:          jcr.MinT,
+		MaxT:          jcr.MaxT,
+		Matchers:      jcr.Matchers,
+		Format:        jcr.Format,
+		Anonymization: jcr.Anonymization,
+		CreatorID:     userID,
+		Delivery:      jcr.Delivery,
+	})
 	if err != nil {
 		h.plugin.API.LogError("error while job create request", "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -95,6 +157,54 @@ func (h *handler) createJobHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (h *handler) cancelJobHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if !model.IsValidId(id) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err := h.plugin.CancelJob(r.Context(), id)
+	switch {
+	case errors.Is(err, errJobNotFound):
+		w.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, errJobNotRunning):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err != nil:
+		h.plugin.API.LogError("error while job cancel request", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (h *handler) retryJobHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if !model.IsValidId(id) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	job, err := h.plugin.RetryJob(r.Context(), id)
+	switch {
+	case errors.Is(err, errJobNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case errors.Is(err, errJobNotRetriable):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		h.plugin.API.LogError("error while job retry request", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(job)
+	if err != nil {
+		h.plugin.API.LogError("error while marshaling the job", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
 func (h *handler) deleteJobHandler(w http.ResponseWriter, r *http.Request) {
 	id := mux.Vars(r)["id"]
 	if !model.IsValidId(id) {
@@ -117,24 +227,63 @@ func (h *handler) deleteAllJobsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// parseJobFilter parses the job list query, the statuses can be given either as repeated
+// or comma separated status parameters.
+func parseJobFilter(query url.Values) (JobFilter, error) {
+	filter := JobFilter{
+		PerPage: defaultJobsPerPage,
+	}
+
+	for _, param := range query["status"] {
+		for _, status := range strings.Split(param, ",") {
+			if !isValidJobStatus(status) {
+				return filter, fmt.Errorf("invalid status: %q", status)
+			}
+			filter.Statuses = append(filter.Statuses, status)
+		}
+	}
+
+	var err error
+	if v := query.Get("created_after"); v != "" {
+		if filter.CreatedAfter, err = strconv.ParseInt(v, 10, 64); err != nil || filter.CreatedAfter < 0 {
+			return filter, errors.New("invalid created_after")
+		}
+	}
+	if v := query.Get("created_before"); v != "" {
+		if filter.CreatedBefore, err = strconv.ParseInt(v, 10, 64); err != nil || filter.CreatedBefore < filter.CreatedAfter {
+			return filter, errors.New("invalid created_before")
+		}
+	}
+
+	if v := query.Get("page"); v != "" {
+		if filter.Page, err = strconv.Atoi(v); err != nil || filter.Page < 0 {
+			return filter, errors.New("invalid page")
+		}
+	}
+	if v := query.Get("per_page"); v != "" {
+		if filter.PerPage, err = strconv.Atoi(v); err != nil || filter.PerPage < 1 || filter.PerPage > maxJobsPerPage {
+			return filter, fmt.Errorf("per_page should be between 1 and %d", maxJobsPerPage)
+		}
+	}
+
+	return filter, nil
+}
+
 func (h *handler) getAllJobsHandler(w http.ResponseWriter, r *http.Request) {
-	jobs, err := h.plugin.GetAllJobs(r.Context())
+	filter, err := parseJobFilter(r.URL.Query())
 	if err != nil {
-		h.plugin.API.LogError("error while job list request", "err", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	jobSlice := make([]*DumpJob, 0, len(jobs))
-	for _, job := range jobs {
-		jobSlice = append(jobSlice, job)
+	jobs, err := h.plugin.ListJobs(r.Context(), filter)
+	if err != nil {
+		h.plugin.API.LogError("error while job list request", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
 
-	sort.Slice(jobSlice, func(i, j int) bool {
-		return jobSlice[i].CreateAt > jobSlice[j].CreateAt
-	})
-
-	b, err := json.Marshal(jobSlice)
+	b, err := json.Marshal(jobs)
 	if err != nil {
 		h.plugin.API.LogError("error while marshaling the jobs", "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -151,30 +300,279 @@ func (h *handler) downloadJobHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	jobs, err := h.plugin.GetAllJobs(r.Context())
+	job, err := h.plugin.GetJob(r.Context(), id)
+	if errors.Is(err, errJobNotFound) {
+		h.plugin.API.LogError("could not find job", "id", id)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		h.plugin.API.LogError("error while job get request", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	fr, err := h.plugin.fileBackend.Reader(job.DumpLocation)
 	if err != nil {
-		h.plugin.API.LogError("error while job list request", "err", err)
+		h.plugin.API.LogError("error while acquiring the file reader", "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	defer fr.Close()
+
+	// the checksum lets the clients detect truncated downloads
+	if job.Checksum != "" {
+		w.Header().Set("X-Checksum-Sha256", job.Checksum)
+	}
+
+	appCfg := h.plugin.API.GetConfig()
+	web.WriteFileResponse(filepath.Base(job.DumpLocation), dumpContentType(job.DumpLocation), 0, time.Now(), *appCfg.ServiceSettings.WebserverMode, fr, true, w, r)
+}
+
+// dumpContentType returns the content type of the dump archive by its file extension.
+func dumpContentType(name string) string {
+	switch {
+	case strings.HasSuffix(name, encryptedFileExt):
+		return "application/octet-stream"
+	case strings.HasSuffix(name, ".tar.gz"):
+		return "application/gzip"
+	case strings.HasSuffix(name, ".zip"):
+		return "application/zip"
+	}
+	return "application/octet-stream"
+}
+
+// verifyJobHandler verifies the dump of the job against the manifest in the archive.
+func (h *handler) verifyJobHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if !model.IsValidId(id) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
 
-	job, ok := jobs[id]
-	if !ok {
+	job, err := h.plugin.GetJob(r.Context(), id)
+	if errors.Is(err, errJobNotFound) {
 		h.plugin.API.LogError("could not find job", "id", id)
 		w.WriteHeader(http.StatusNotFound)
 		return
+	} else if err != nil {
+		h.plugin.API.LogError("error while job get request", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
 
-	fr, err := h.plugin.fileBackend.Reader(job.DumpLocation)
+	result, err := h.plugin.VerifyDump(r.Context(), job)
 	if err != nil {
-		h.plugin.API.LogError("error while acquiring the file reader", "err", err)
+		h.plugin.API.LogError("error while verifying the dump", "id", id, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	b, err := json.Marshal(result)
+	if err != nil {
+		h.plugin.API.LogError("error while marshaling the verification result", "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	defer fr.Close()
 
-	appCfg := h.plugin.API.GetConfig()
-	web.WriteFileResponse(filepath.Base(job.DumpLocation), "application/zip", 0, time.Now(), *appCfg.ServiceSettings.WebserverMode, fr, true, w, r)
+	w.Write(b)
+}
+
+type ScheduleCreateRequest struct {
+	Hour          int `json:"hour"`
+	Minute        int `json:"minute"`
+	IntervalHours int `json:"interval_hours"`
+	RangeHours    int `json:"range_hours"`
+	Keep          int `json:"keep"`
+}
+
+func (h *handler) createScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	var scr ScheduleCreateRequest
+	err := json.NewDecoder(r.Body).Decode(&scr)
+	if err != nil {
+		h.plugin.API.LogError("error while processing the request", "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	schedule := &DumpSchedule{
+		Hour:          scr.Hour,
+		Minute:        scr.Minute,
+		IntervalHours: scr.IntervalHours,
+		RangeHours:    scr.RangeHours,
+		Keep:          scr.Keep,
+	}
+	if err = schedule.IsValid(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	schedule, err = h.plugin.CreateSchedule(r.Context(), schedule)
+	if err != nil {
+		h.plugin.API.LogError("error while schedule create request", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(schedule)
+	if err != nil {
+		h.plugin.API.LogError("error while marshaling the schedule", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *handler) deleteScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if !model.IsValidId(id) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := h.plugin.DeleteSchedule(r.Context(), id); err != nil {
+		h.plugin.API.LogError("error while schedule delete request", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *handler) getAllSchedulesHandler(w http.ResponseWriter, r *http.Request) {
+	schedules, err := h.plugin.GetAllSchedules(r.Context())
+	if err != nil {
+		h.plugin.API.LogError("error while schedule list request", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	scheduleSlice := make([]*DumpSchedule, 0, len(schedules))
+	for _, schedule := range schedules {
+		scheduleSlice = append(scheduleSlice, schedule)
+	}
+
+	sort.Slice(scheduleSlice, func(i, j int) bool {
+		return scheduleSlice[i].CreateAt > scheduleSlice[j].CreateAt
+	})
+
+	b, err := json.Marshal(scheduleSlice)
+	if err != nil {
+		h.plugin.API.LogError("error while marshaling the schedules", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(b)
+}
+
+// uploadImportHandler stores the dump archive in the request body and schedules a job to
+// import it into a separate namespace.
+func (h *handler) uploadImportHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	cfg, err := h.plugin.getConfiguration()
+	if err != nil {
+		h.plugin.API.LogError("error while getting the configuration", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	maxBytes := int64(*cfg.ImportMaxSizeMB) * 1024 * 1024
+	if r.ContentLength > maxBytes {
+		http.Error(w, "the archive exceeds the maximum import size", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	job, err := h.plugin.CreateImportJob(r.Context(), http.MaxBytesReader(w, r.Body, maxBytes))
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		http.Error(w, "the archive exceeds the maximum import size", http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		h.plugin.API.LogError("error while import request", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	b, err := json.Marshal(job)
+	if err != nil {
+		h.plugin.API.LogError("error while marshaling the job", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	w.Write(b)
+}
+
+// exportHandler exports the selected series as a table, the series are selected with the
+// repeated "match" query parameter within the "min_t" and "max_t" range. At least one selector
+// is required. The table is streamed into the response as it's written, hence an error after
+// the streaming started can only be logged and the response is truncated.
+func (h *handler) exportHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	format := query.Get("format")
+	if !isValidExportFormat(format) {
+		http.Error(w, "unknown export format", http.StatusBadRequest)
+		return
+	}
+
+	minT, err := strconv.ParseInt(query.Get("min_t"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid min_t", http.StatusBadRequest)
+		return
+	}
+
+	maxT, err := strconv.ParseInt(query.Get("max_t"), 10, 64)
+	if err != nil || maxT < minT {
+		http.Error(w, "invalid max_t", http.StatusBadRequest)
+		return
+	}
+
+	// exporting every series is not allowed, the selectors should be given
+	selectors := query["match"]
+	if len(selectors) == 0 {
+		http.Error(w, "at least one match selector is required", http.StatusBadRequest)
+		return
+	}
+	if _, err = parseMatcherSets(selectors); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// the samples are read from an imported dump if the import id is given
+	importID := query.Get("import_id")
+	if importID != "" && !model.IsValidId(importID) {
+		http.Error(w, "invalid import_id", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Type", exportContentTypes[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "metrics_export."+format))
+
+	ew := &exportResponseWriter{w: w}
+	err = h.plugin.Export(r.Context(), format, minT, maxT, selectors, importID, ew)
+	if err != nil {
+		h.plugin.API.LogError("error while exporting metrics", "err", err)
+		if !ew.written {
+			w.Header().Del("Content-Disposition")
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+}
+
+// exportResponseWriter tracks whether the export is started to be streamed to the response.
+type exportResponseWriter struct {
+	w       io.Writer
+	written bool
+}
+
+func (w *exportResponseWriter) Write(b []byte) (int, error) {
+	if len(b) > 0 {
+		w.written = true
+	}
+	return w.w.Write(b)
 }
 
 func (h *handler) getStatsHandler(w http.ResponseWriter, _ *http.Request) {
@@ -192,3 +590,19 @@ func (h *handler) getStatsHandler(w http.ResponseWriter, _ *http.Request) {
 		return
 	}
 }
+
+func (h *handler) getClusterStatusHandler(w http.ResponseWriter, _ *http.Request) {
+	status, err := h.plugin.GetClusterStatus()
+	if err != nil {
+		h.plugin.API.LogError("error while retrieving cluster status", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(status)
+	if err != nil {
+		h.plugin.API.LogError("error while marshaling cluster status", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
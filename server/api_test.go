@@ -0,0 +1,100 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/v8/platform/shared/filestore"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	pluginmocks "github.com/mattermost/mattermost-plugin-metrics/server/mocks/github.com/mattermost/mattermost/server/public/plugin"
+)
+
+func TestDumpContentType(t *testing.T) {
+	require.Equal(t, "application/gzip", dumpContentType("dump/id/"+zipFileName))
+	require.Equal(t, "application/gzip", dumpContentType("dump/id/"+openMetricsZipFileName))
+	require.Equal(t, "application/octet-stream", dumpContentType("dump/id/"+zipFileName+encryptedFileExt))
+	require.Equal(t, "application/zip", dumpContentType("dump/id/dump.zip"))
+}
+
+func TestParseJobFilter(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		filter, err := parseJobFilter(url.Values{})
+		require.NoError(t, err)
+		require.Equal(t, JobFilter{PerPage: defaultJobsPerPage}, filter)
+	})
+
+	t.Run("all parameters", func(t *testing.T) {
+		query, err := url.ParseQuery("status=success,error&status=canceled&created_after=10&created_before=20&page=2&per_page=5")
+		require.NoError(t, err)
+
+		filter, err := parseJobFilter(query)
+		require.NoError(t, err)
+		require.Equal(t, JobFilter{
+			Statuses:      []string{model.JobStatusSuccess, model.JobStatusError, model.JobStatusCanceled},
+			CreatedAfter:  10,
+			CreatedBefore: 20,
+			Page:          2,
+			PerPage:       5,
+		}, filter)
+	})
+
+	for _, query := range []string{
+		"status=unknown",
+		"created_after=abc",
+		"created_after=20&created_before=10",
+		"page=-1",
+		"per_page=0",
+		"per_page=1000",
+	} {
+		t.Run(query, func(t *testing.T) {
+			values, err := url.ParseQuery(query)
+			require.NoError(t, err)
+
+			_, err = parseJobFilter(values)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestExportHandler(t *testing.T) {
+	fs, err := filestore.NewFileBackend(filestore.FileBackendSettings{
+		DriverName: "local",
+		Directory:  t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	api := &pluginmocks.MockAPI{}
+	api.On("LogDebug", mock.Anything, mock.Anything, mock.Anything).Maybe()
+
+	plugin := &Plugin{
+		fileBackend: fs,
+		logger:      log.NewNopLogger(),
+	}
+	plugin.SetAPI(api)
+	h := &handler{plugin: plugin}
+	t.Cleanup(func() { os.Remove("export") })
+
+	t.Run("without selectors", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.exportHandler(w, httptest.NewRequest(http.MethodGet, "/tsdb/export?format=csv&min_t=0&max_t=1000", nil))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("streamed", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.exportHandler(w, httptest.NewRequest(http.MethodGet, "/tsdb/export?format=csv&min_t=0&max_t=1000&match=go_goroutines", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, `attachment; filename="metrics_export.csv"`, w.Header().Get("Content-Disposition"))
+		require.Equal(t, "timestamp,metric,labels,value\n", w.Body.String())
+	})
+}
@@ -0,0 +1,198 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"filippo.io/age"
+)
+
+// archiveWriter writes a tar.gz archive directly into the file store, so that the archive is
+// uploaded while it's being written and there is no local copy of it. The checksums of the
+// added files are recorded for the manifest.
+type archiveWriter struct {
+	tw *tar.Writer
+	zw *gzip.Writer
+	// ew is the encrypting writer, nil if the archive is not encrypted.
+	ew io.WriteCloser
+	pw *io.PipeWriter
+
+	// hash is the checksum and size is the size of the archive as stored in the file store.
+	hash     hash.Hash
+	size     byteCounter
+	files    []ManifestFile
+	uploaded chan error
+
+	// waitOnce guards uploadErr, the result of the upload received from uploaded.
+	waitOnce  sync.Once
+	uploadErr error
+}
+
+// newArchiveWriter starts uploading an archive to the path in the file store. If the recipient
+// is set, the archive is encrypted for the recipient. The uploaded bytes are reported to the
+// progress. Either close or abort should be called to finish the upload.
+func (p *Plugin) newArchiveWriter(location string, recipient age.Recipient, progress *progressReporter) (*archiveWriter, error) {
+	pr, pw := io.Pipe()
+	a := &archiveWriter{
+		pw:       pw,
+		hash:     sha256.New(),
+		uploaded: make(chan error, 1),
+	}
+
+	go func() {
+		_, err := p.fileBackend.WriteFile(pr, location)
+		// if the upload fails, the writes to the pipe should fail too
+		pr.CloseWithError(err)
+		a.uploaded <- err
+	}()
+
+	var w io.Writer = io.MultiWriter(pw, a.hash, &a.size, progressWriter{progress: progress})
+	if recipient != nil {
+		ew, err := age.Encrypt(w, recipient)
+		if err != nil {
+			a.abort(err)
+			return nil, err
+		}
+		a.ew = ew
+		w = ew
+	}
+
+	a.zw = gzip.NewWriter(w)
+	a.tw = tar.NewWriter(a.zw)
+
+	return a, nil
+}
+
+// addFile writes the contents of r as a file to the archive. The size should be known
+// beforehand as the tar format requires.
+func (a *archiveWriter) addFile(name string, r io.Reader, size int64, modTime time.Time) error {
+	err := a.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0600,
+		ModTime:  modTime,
+	})
+	if err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	if _, err = io.Copy(io.MultiWriter(a.tw, h), r); err != nil {
+		return err
+	}
+
+	a.files = append(a.files, ManifestFile{
+		Path:   name,
+		Size:   size,
+		SHA256: hex.EncodeToString(h.Sum(nil)),
+	})
+
+	return nil
+}
+
+// addDirectory writes the regular files under dir to the archive, the file names are
+// prefixed with prefix.
+func (a *archiveWriter) addDirectory(dir, prefix string) error {
+	return filepath.Walk(dir, func(file string, fi os.FileInfo, wErr error) error {
+		if wErr != nil {
+			return wErr
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		return a.addFile(path.Join(prefix, filepath.ToSlash(rel)), f, fi.Size(), fi.ModTime())
+	})
+}
+
+// close writes the manifest with the checksums of the added files, finishes the archive
+// and waits for the upload. It returns the checksum of the uploaded archive.
+func (a *archiveWriter) close(manifest *DumpManifest) (string, error) {
+	manifest.Files = a.files
+
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		a.abort(err)
+		return "", err
+	}
+
+	err = a.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     manifestFileName,
+		Size:     int64(len(b)),
+		Mode:     0600,
+		ModTime:  time.Now(),
+	})
+	if err == nil {
+		_, err = io.Copy(a.tw, bytes.NewReader(b))
+	}
+
+	for _, c := range []io.Closer{a.tw, a.zw, a.ew} {
+		if err != nil {
+			break
+		}
+		if c != nil {
+			err = c.Close()
+		}
+	}
+	if err != nil {
+		a.abort(err)
+		return "", err
+	}
+
+	a.pw.Close()
+	if err = a.wait(); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(a.hash.Sum(nil)), nil
+}
+
+// byteCounter counts the bytes written to it.
+type byteCounter int64
+
+func (c *byteCounter) Write(b []byte) (int, error) {
+	*c += byteCounter(len(b))
+	return len(b), nil
+}
+
+// abort stops the upload, the partially uploaded archive should be removed by the caller. It
+// can be called after close, e.g. if closing failed.
+func (a *archiveWriter) abort(err error) {
+	a.pw.CloseWithError(err)
+	a.wait()
+}
+
+// wait waits for the upload to finish and returns its result, it can be called multiple times.
+func (a *archiveWriter) wait() error {
+	a.waitOnce.Do(func() {
+		a.uploadErr = <-a.uploaded
+	})
+	return a.uploadErr
+}
@@ -4,6 +4,11 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
 	"github.com/jmoiron/sqlx"
 	"github.com/mattermost/squirrel"
 	"github.com/pkg/errors"
@@ -52,3 +57,96 @@ func topologyChanged(a, b []*model.ClusterDiscovery) bool {
 
 	return false
 }
+
+const (
+	KVStoreLeaderKey = PluginName + "_leader_status"
+
+	// leaderStatusExpiry is how long the leader status is kept without being refreshed. The
+	// collecting node refreshes it every minute, a crashed node is no longer reported after it.
+	leaderStatusExpiry = 3 * time.Minute
+)
+
+type ClusterNode struct {
+	ID       string `json:"id"`
+	Hostname string `json:"hostname"`
+}
+
+// LeaderStatus is written by the node holding the singleton lock, so that
+// any other node can tell which one is collecting the metrics.
+type LeaderStatus struct {
+	Hostname    string        `json:"hostname"`
+	LeaderSince int64         `json:"leader_since"`
+	Topology    []ClusterNode `json:"topology"`
+	// LastTargetSyncAt is the last time the scrape targets were checked against the
+	// cluster topology, they are regenerated only if the topology changed.
+	LastTargetSyncAt int64 `json:"last_target_sync_at"`
+}
+
+type ClusterStatus struct {
+	HA       bool          `json:"ha"`
+	Hostname string        `json:"hostname"`
+	IsLeader bool          `json:"is_leader"`
+	Leader   *LeaderStatus `json:"leader"`
+}
+
+func newClusterNodes(list []*model.ClusterDiscovery) []ClusterNode {
+	nodes := make([]ClusterNode, 0, len(list))
+	for _, node := range list {
+		nodes = append(nodes, ClusterNode{
+			ID:       node.Id,
+			Hostname: node.Hostname,
+		})
+	}
+
+	return nodes
+}
+
+func (p *Plugin) setLeaderStatus(status *LeaderStatus) error {
+	b, err := json.Marshal(status)
+	if err != nil {
+		return fmt.Errorf("could not marshal leader status: %w", err)
+	}
+
+	if appErr := p.API.KVSetWithExpiry(KVStoreLeaderKey, b, int64(leaderStatusExpiry/time.Second)); appErr != nil {
+		return fmt.Errorf("could not store leader status: %w", appErr)
+	}
+
+	return nil
+}
+
+func (p *Plugin) getLeaderStatus() (*LeaderStatus, error) {
+	b, appErr := p.API.KVGet(KVStoreLeaderKey)
+	if appErr != nil {
+		return nil, fmt.Errorf("could not retrieve leader status: %w", appErr)
+	}
+
+	if len(b) == 0 {
+		return nil, nil
+	}
+
+	var status LeaderStatus
+	if err := json.Unmarshal(b, &status); err != nil {
+		return nil, fmt.Errorf("could not unmarshal leader status: %w", err)
+	}
+
+	return &status, nil
+}
+
+func (p *Plugin) GetClusterStatus() (*ClusterStatus, error) {
+	leader, err := p.getLeaderStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("could not get hostname: %w", err)
+	}
+
+	return &ClusterStatus{
+		HA:       p.isHA(),
+		Hostname: hostname,
+		IsLeader: !p.isHA() || p.singletonLockAcquired,
+		Leader:   leader,
+	}, nil
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	pluginmocks "github.com/mattermost/mattermost-plugin-metrics/server/mocks/github.com/mattermost/mattermost/server/public/plugin"
+)
+
+func TestLeaderStatus(t *testing.T) {
+	var stored []byte
+	var expiry int64
+	api := &pluginmocks.MockAPI{}
+	api.On("KVGet", KVStoreLeaderKey).Return(func(string) ([]byte, *model.AppError) {
+		return stored, nil
+	})
+	api.On("KVSetWithExpiry", KVStoreLeaderKey, mock.Anything, mock.Anything).Return(func(_ string, value []byte, expireInSeconds int64) *model.AppError {
+		stored, expiry = value, expireInSeconds
+		return nil
+	})
+
+	plugin := &Plugin{}
+	plugin.SetAPI(api)
+
+	status, err := plugin.getLeaderStatus()
+	require.NoError(t, err)
+	require.Nil(t, status)
+
+	expected := &LeaderStatus{
+		Hostname:         "node-1",
+		LeaderSince:      10,
+		Topology:         []ClusterNode{{ID: "id1", Hostname: "node-1"}, {ID: "id2", Hostname: "node-2"}},
+		LastTargetSyncAt: 20,
+	}
+	require.NoError(t, plugin.setLeaderStatus(expected))
+	// the status expires unless the collecting node refreshes it
+	require.Equal(t, int64(leaderStatusExpiry.Seconds()), expiry)
+
+	status, err = plugin.getLeaderStatus()
+	require.NoError(t, err)
+	require.Equal(t, expected, status)
+}
+
+func TestGetClusterStatusHandler(t *testing.T) {
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+
+	leader := &LeaderStatus{
+		Hostname:    "node-1",
+		LeaderSince: 10,
+		Topology:    []ClusterNode{{ID: "id1", Hostname: "node-1"}},
+	}
+	b, err := json.Marshal(leader)
+	require.NoError(t, err)
+
+	for name, tc := range map[string]struct {
+		stored       []byte
+		lockAcquired bool
+		expected     ClusterStatus
+	}{
+		"leader": {
+			stored:       b,
+			lockAcquired: true,
+			expected:     ClusterStatus{HA: true, Hostname: hostname, IsLeader: true, Leader: leader},
+		},
+		"not leader": {
+			stored:   b,
+			expected: ClusterStatus{HA: true, Hostname: hostname, Leader: leader},
+		},
+		"leader status expired": {
+			expected: ClusterStatus{HA: true, Hostname: hostname},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			api := &pluginmocks.MockAPI{}
+			api.On("KVGet", KVStoreLeaderKey).Return(tc.stored, nil)
+			api.On("GetConfig").Return(&model.Config{ClusterSettings: model.ClusterSettings{Enable: model.NewBool(true)}})
+
+			plugin := &Plugin{singletonLockAcquired: tc.lockAcquired}
+			plugin.SetAPI(api)
+			h := &handler{plugin: plugin}
+
+			w := httptest.NewRecorder()
+			h.getClusterStatusHandler(w, httptest.NewRequest(http.MethodGet, "/cluster/status", nil))
+			require.Equal(t, http.StatusOK, w.Code)
+
+			var status ClusterStatus
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&status))
+			require.Equal(t, tc.expected, status)
+		})
+	}
+}
@@ -0,0 +1,329 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/units"
+	promModel "github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/storage"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+)
+
+const (
+	commandTrigger = "metrics"
+
+	// commandJobsLimit is the number of the recent jobs listed by the jobs command.
+	commandJobsLimit = 10
+	// commandQueryTimeout is the timeout of the queries run by the query command.
+	commandQueryTimeout = 30 * time.Second
+	// commandQueryMaxSamples is the maximum number of samples loaded by a query.
+	commandQueryMaxSamples = 5000000
+	// commandQueryMaxResults is the maximum number of the series listed in the query result.
+	commandQueryMaxResults = 50
+)
+
+const commandHelp = `###### Mattermost Metrics Plugin - Slash Command Help
+* |/metrics dump last <duration>| - Create a dump of the last duration, e.g. |6h| or |2d|. The dump is uploaded to this channel once it's created.
+* |/metrics jobs| - List the recent dump jobs.
+* |/metrics stats| - Show the statistics of the stored metrics.
+* |/metrics targets| - List the scrape targets and their health.
+* |/metrics query <promql>| - Evaluate a PromQL query at the current time.`
+
+func (p *Plugin) registerCommand() error {
+	dump := model.NewAutocompleteData("dump", "last [duration]", "Create a dump of the last duration and upload it to this channel")
+	dump.AddTextArgument("Duration, e.g. 6h or 2d", "last [duration]", "")
+
+	query := model.NewAutocompleteData("query", "[promql]", "Evaluate a PromQL query at the current time")
+	query.AddTextArgument("PromQL query, e.g. up", "[promql]", "")
+
+	data := model.NewAutocompleteData(commandTrigger, "[command]", "Available commands: dump, jobs, stats, targets, query, help")
+	data.AddCommand(dump)
+	data.AddCommand(model.NewAutocompleteData("jobs", "", "List the recent dump jobs"))
+	data.AddCommand(model.NewAutocompleteData("stats", "", "Show the statistics of the stored metrics"))
+	data.AddCommand(model.NewAutocompleteData("targets", "", "List the scrape targets and their health"))
+	data.AddCommand(query)
+	data.AddCommand(model.NewAutocompleteData("help", "", "Show the help"))
+	data.RoleID = model.SystemAdminRoleId
+
+	err := p.API.RegisterCommand(&model.Command{
+		Trigger:          commandTrigger,
+		DisplayName:      "Metrics",
+		Description:      "Manage the metrics collected by the Mattermost Metrics Plugin",
+		AutoComplete:     true,
+		AutoCompleteDesc: "Available commands: dump, jobs, stats, targets, query, help",
+		AutoCompleteHint: "[command]",
+		AutocompleteData: data,
+	})
+	if err != nil {
+		return fmt.Errorf("could not register the command: %w", err)
+	}
+
+	return nil
+}
+
+func (p *Plugin) ExecuteCommand(_ *plugin.Context, args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+	if !p.API.HasPermissionTo(args.UserId, model.PermissionManageSystem) {
+		return ephemeralResponse("Only the system admins can run this command."), nil
+	}
+
+	fields := strings.Fields(args.Command)
+	if len(fields) < 2 {
+		return ephemeralResponse(commandHelpText()), nil
+	}
+
+	var text string
+	var err error
+	ctx := context.Background()
+	switch fields[1] {
+	case "dump":
+		text, err = p.executeDumpCommand(ctx, args, fields[2:])
+	case "jobs":
+		text, err = p.executeJobsCommand(ctx)
+	case "stats":
+		text, err = p.executeStatsCommand()
+	case "targets":
+		text, err = p.runOnCollectingNode(ctx, commandRequest{Command: commandTargets})
+	case "query":
+		// the query may contain spaces, we take the rest of the command as it is
+		qs := strings.TrimSpace(strings.SplitN(strings.TrimSpace(args.Command), "query", 2)[1])
+		if qs == "" {
+			err = errors.New("usage: /metrics query <promql>, e.g. /metrics query up")
+			break
+		}
+		text, err = p.runOnCollectingNode(ctx, commandRequest{Command: commandQuery, Query: qs})
+	default:
+		text = commandHelpText()
+	}
+	if err != nil {
+		return ephemeralResponse(fmt.Sprintf("Error: %s", err)), nil
+	}
+
+	return ephemeralResponse(text), nil
+}
+
+func (p *Plugin) executeDumpCommand(ctx context.Context, args *model.CommandArgs, params []string) (string, error) {
+	if len(params) != 2 || params[0] != "last" {
+		return "", errors.New("usage: /metrics dump last <duration>, e.g. /metrics dump last 6h")
+	}
+
+	d, err := promModel.ParseDuration(params[1])
+	if err != nil || d <= 0 {
+		return "", fmt.Errorf("invalid duration: %q", params[1])
+	}
+
+	delivery := &DumpDelivery{
+		Type:      DeliveryTypeChannel,
+		ChannelID: args.ChannelId,
+	}
+	if err = p.validateDelivery(delivery, args.UserId); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	job, err := p.CreateJob(ctx, &DumpJob{
+		MinT:      now.Add(-time.Duration(d)).UnixMilli(),
+		MaxT:      now.UnixMilli(),
+		CreatorID: args.UserId,
+		Delivery:  delivery,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("Dump job `%s` of the last %s is created, the dump will be uploaded to this channel once it's ready.", job.ID, d), nil
+}
+
+func (p *Plugin) executeJobsCommand(ctx context.Context) (string, error) {
+	jobs, err := p.ListJobs(ctx, JobFilter{PerPage: commandJobsLimit})
+	if err != nil {
+		return "", err
+	}
+
+	if len(jobs.Jobs) == 0 {
+		return "There are no jobs.", nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString("| ID | Created At | Range | Status | Size |\n|:--|:--|:--|:--|:--|\n")
+	for _, job := range jobs.Jobs {
+		status := job.Status
+		if job.Error != "" {
+			status += ": " + job.Error
+		} else if job.Progress != nil {
+			status += " (" + job.Progress.Phase + ")"
+		}
+
+		size := "-"
+		if job.Size > 0 {
+			size = units.Base2Bytes(job.Size).Round(1).String()
+		}
+
+		fmt.Fprintf(&sb, "| `%s` | %s | %s - %s | %s | %s |\n", job.ID, formatMillis(job.CreateAt), formatMillis(job.MinT), formatMillis(job.MaxT), status, size)
+	}
+	if jobs.Total > len(jobs.Jobs) {
+		fmt.Fprintf(&sb, "\nShowing %d of %d jobs.", len(jobs.Jobs), jobs.Total)
+	}
+
+	return sb.String(), nil
+}
+
+func (p *Plugin) executeStatsCommand() (string, error) {
+	stats, err := p.GetTSDBStats()
+	if err != nil {
+		return "", err
+	}
+
+	if stats.NumSamples == 0 {
+		return "There are no metrics in the file store yet.", nil
+	}
+
+	return fmt.Sprintf("| Stat | Value |\n|:--|:--|\n| Minimum Time | %s |\n| Maximum Time | %s |\n| Number of Series | %d |\n| Number of Samples | %d |",
+		formatMillis(stats.MinT), formatMillis(stats.MaxT), stats.NumSeries, stats.NumSamples), nil
+}
+
+// listTargets lists the scrape targets of this node, it should be collecting the metrics.
+func (p *Plugin) listTargets() (string, error) {
+	p.tsdbLock.RLock()
+	manager := p.scrapeManager
+	p.tsdbLock.RUnlock()
+	if manager == nil {
+		return "", errCollectingNodeOnly
+	}
+
+	var rows []string
+	for _, targets := range manager.TargetsActive() {
+		for _, target := range targets {
+			lastError := ""
+			if err := target.LastError(); err != nil {
+				lastError = err.Error()
+			}
+
+			lastScrape := "-"
+			if !target.LastScrape().IsZero() {
+				lastScrape = target.LastScrape().UTC().Format(time.RFC3339)
+			}
+
+			lset := target.Labels()
+			rows = append(rows, fmt.Sprintf("| %s | %s | %s | %s | %s |", lset.Get("job"), lset.Get("instance"), target.Health(), lastScrape, lastError))
+		}
+	}
+
+	if len(rows) == 0 {
+		return "There are no scrape targets.", nil
+	}
+	sort.Strings(rows)
+
+	return "| Job | Instance | Health | Last Scrape | Last Error |\n|:--|:--|:--|:--|:--|\n" + strings.Join(rows, "\n"), nil
+}
+
+// runQuery evaluates the query against the local tsdb, this node should be collecting the
+// metrics.
+func (p *Plugin) runQuery(ctx context.Context, qs string) (string, error) {
+	p.tsdbLock.RLock()
+	collecting := p.db != nil
+	p.tsdbLock.RUnlock()
+	if !collecting {
+		return "", errCollectingNodeOnly
+	}
+
+	engine := promql.NewEngine(promql.EngineOpts{
+		Logger:     p.logger,
+		MaxSamples: commandQueryMaxSamples,
+		Timeout:    commandQueryTimeout,
+	})
+
+	ctx, cancel := context.WithTimeout(ctx, commandQueryTimeout)
+	defer cancel()
+
+	// the lock is held only while creating the queriers, so that a long query doesn't block
+	// the writers of the lock, and the readers waiting behind them.
+	query, err := engine.NewInstantQuery(ctx, localQueryable{p: p}, nil, qs, time.Now())
+	if err != nil {
+		return "", err
+	}
+	defer query.Close()
+
+	result := query.Exec(ctx)
+	if result.Err != nil {
+		return "", result.Err
+	}
+
+	return formatQueryResult(result.Value), nil
+}
+
+var errCollectingNodeOnly = errors.New("this command is only available on the node collecting the metrics")
+
+// localQueryable reads the local tsdb of the plugin. The tsdb waits for the open queriers
+// before it's closed, hence the lock is not held while the queriers are in use.
+type localQueryable struct {
+	p *Plugin
+}
+
+func (q localQueryable) Querier(mint, maxt int64) (storage.Querier, error) {
+	q.p.tsdbLock.RLock()
+	defer q.p.tsdbLock.RUnlock()
+
+	if q.p.db == nil {
+		return nil, errCollectingNodeOnly
+	}
+
+	return q.p.db.Querier(mint, maxt)
+}
+
+// formatQueryResult formats the result of an instant query as a markdown table.
+func formatQueryResult(value any) string {
+	switch v := value.(type) {
+	case promql.Scalar:
+		return fmt.Sprintf("`%g`", v.V)
+	case promql.String:
+		return fmt.Sprintf("`%s`", v.V)
+	case promql.Vector:
+		if len(v) == 0 {
+			return "The query returned no results."
+		}
+
+		var sb strings.Builder
+		sb.WriteString("| Series | Value |\n|:--|:--|\n")
+		for i, sample := range v {
+			if i == commandQueryMaxResults {
+				fmt.Fprintf(&sb, "\nShowing %d of %d series.", commandQueryMaxResults, len(v))
+				break
+			}
+
+			value := fmt.Sprintf("%g", sample.F)
+			if sample.H != nil {
+				value = sample.H.String()
+			}
+			fmt.Fprintf(&sb, "| `%s` | %s |\n", sample.Metric.String(), value)
+		}
+		return sb.String()
+	}
+
+	return fmt.Sprintf("```\n%v\n```", value)
+}
+
+func formatMillis(ms int64) string {
+	return time.UnixMilli(ms).UTC().Format(time.RFC3339)
+}
+
+func commandHelpText() string {
+	return strings.ReplaceAll(commandHelp, "|", "`")
+}
+
+func ephemeralResponse(text string) *model.CommandResponse {
+	return &model.CommandResponse{
+		ResponseType: model.CommandResponseTypeEphemeral,
+		Text:         text,
+	}
+}
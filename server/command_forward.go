@@ -0,0 +1,154 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	clusterEventCommand           = "command"
+	KVStoreCommandResultKeyPrefix = PluginName + "_command_result_"
+
+	// commandForwardTimeout is the maximum duration of waiting for the collecting node to run
+	// a forwarded command, the query timeout plus some time to publish the result.
+	commandForwardTimeout      = commandQueryTimeout + 15*time.Second
+	commandForwardPollInterval = 500 * time.Millisecond
+	commandResultExpiry        = 5 * time.Minute
+
+	commandTargets = "targets"
+	commandQuery   = "query"
+)
+
+// commandRequest is sent by the node receiving a command that reads the local tsdb or the
+// scrape targets to the node collecting the metrics.
+type commandRequest struct {
+	ID      string `json:"id"`
+	Command string `json:"command"`
+	Query   string `json:"query,omitempty"`
+}
+
+type commandResult struct {
+	Text  string `json:"text"`
+	Error string `json:"error"`
+}
+
+// runOnCollectingNode runs the command on this node if it's collecting the metrics, otherwise
+// the command is forwarded to the collecting node.
+func (p *Plugin) runOnCollectingNode(ctx context.Context, req commandRequest) (string, error) {
+	text, err := p.runLocalCommand(ctx, req)
+	if !errors.Is(err, errCollectingNodeOnly) || !p.isHA() {
+		return text, err
+	}
+
+	return p.forwardCommand(ctx, req)
+}
+
+func (p *Plugin) runLocalCommand(ctx context.Context, req commandRequest) (string, error) {
+	switch req.Command {
+	case commandTargets:
+		return p.listTargets()
+	case commandQuery:
+		return p.runQuery(ctx, req.Query)
+	}
+
+	return "", fmt.Errorf("unknown command: %q", req.Command)
+}
+
+// forwardCommand publishes the command to the other nodes and waits for the collecting node
+// to store its result.
+func (p *Plugin) forwardCommand(ctx context.Context, req commandRequest) (string, error) {
+	req.ID = model.NewId()
+	b, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+
+	err = p.API.PublishPluginClusterEvent(model.PluginClusterEvent{
+		Id:   clusterEventCommand,
+		Data: b,
+	}, model.PluginClusterEventSendOptions{
+		SendType: model.PluginClusterEventSendTypeReliable,
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not publish command request: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, commandForwardTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(commandForwardPollInterval)
+	defer ticker.Stop()
+
+	key := KVStoreCommandResultKeyPrefix + req.ID
+	for {
+		select {
+		case <-ctx.Done():
+			return "", errors.New("the node collecting the metrics did not respond in time")
+		case <-ticker.C:
+		}
+
+		b, appErr := p.API.KVGet(key)
+		if appErr != nil {
+			return "", fmt.Errorf("could not retrieve command result: %w", appErr)
+		} else if len(b) == 0 {
+			continue
+		}
+		p.API.KVDelete(key)
+
+		var result commandResult
+		if err = json.Unmarshal(b, &result); err != nil {
+			return "", fmt.Errorf("could not unmarshal command result: %w", err)
+		}
+
+		if result.Error != "" {
+			return "", errors.New(result.Error)
+		}
+
+		return result.Text, nil
+	}
+}
+
+func (p *Plugin) handleCommandEvent(data []byte) {
+	p.tsdbLock.RLock()
+	collecting := p.db != nil
+	p.tsdbLock.RUnlock()
+	if !collecting {
+		return
+	}
+
+	var req commandRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		p.API.LogError("could not unmarshal command request", "err", err)
+		return
+	}
+
+	// the hook should return as soon as possible, we run the command in the background. The
+	// command is bounded by the query timeout, it's not waited for while deactivating as it
+	// takes the tsdb lock held by OnDeactivate.
+	go func() {
+		var result commandResult
+		text, err := p.runLocalCommand(context.TODO(), req)
+		if err != nil {
+			result.Error = err.Error()
+		}
+		result.Text = text
+
+		b, err := json.Marshal(result)
+		if err != nil {
+			p.API.LogError("could not marshal command result", "err", err)
+			return
+		}
+
+		if appErr := p.API.KVSetWithExpiry(KVStoreCommandResultKeyPrefix+req.ID, b, int64(commandResultExpiry/time.Second)); appErr != nil {
+			p.API.LogError("could not store command result", "err", appErr)
+		}
+	}()
+}
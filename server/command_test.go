@@ -0,0 +1,117 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	pluginmocks "github.com/mattermost/mattermost-plugin-metrics/server/mocks/github.com/mattermost/mattermost/server/public/plugin"
+)
+
+func TestExecuteCommand(t *testing.T) {
+	api := &pluginmocks.MockAPI{}
+	api.On("HasPermissionTo", "admin", model.PermissionManageSystem).Return(true)
+	api.On("HasPermissionTo", "user", model.PermissionManageSystem).Return(false)
+	api.On("GetConfig").Return(&model.Config{})
+
+	plugin := &Plugin{
+		logger: log.NewNopLogger(),
+	}
+	plugin.SetAPI(api)
+
+	t.Run("not a system admin", func(t *testing.T) {
+		resp, appErr := plugin.ExecuteCommand(nil, &model.CommandArgs{UserId: "user", Command: "/metrics jobs"})
+		require.Nil(t, appErr)
+		require.Contains(t, resp.Text, "Only the system admins")
+	})
+
+	t.Run("help", func(t *testing.T) {
+		resp, appErr := plugin.ExecuteCommand(nil, &model.CommandArgs{UserId: "admin", Command: "/metrics"})
+		require.Nil(t, appErr)
+		require.Equal(t, model.CommandResponseTypeEphemeral, resp.ResponseType)
+		require.Contains(t, resp.Text, "`/metrics dump last <duration>`")
+	})
+
+	t.Run("invalid dump duration", func(t *testing.T) {
+		resp, appErr := plugin.ExecuteCommand(nil, &model.CommandArgs{UserId: "admin", Command: "/metrics dump last six"})
+		require.Nil(t, appErr)
+		require.Contains(t, resp.Text, "invalid duration")
+	})
+
+	t.Run("query on a node not collecting", func(t *testing.T) {
+		resp, appErr := plugin.ExecuteCommand(nil, &model.CommandArgs{UserId: "admin", Command: "/metrics query up"})
+		require.Nil(t, appErr)
+		require.Contains(t, resp.Text, errCollectingNodeOnly.Error())
+	})
+}
+
+func TestExecuteQueryCommand(t *testing.T) {
+	now := time.Now().Truncate(time.Minute).UnixMilli()
+	db := createTestTSDB(t, now-time.Hour.Milliseconds(), now, labels.FromStrings(labels.MetricName, "up", "job", "prometheus"))
+
+	api := &pluginmocks.MockAPI{}
+	api.On("LogDebug", mock.Anything, mock.Anything, mock.Anything).Maybe()
+
+	plugin := &Plugin{
+		db:     db,
+		logger: log.NewNopLogger(),
+	}
+	plugin.SetAPI(api)
+
+	text, err := plugin.runQuery(context.Background(), `count(up{job="prometheus"})`)
+	require.NoError(t, err)
+	require.Contains(t, text, "| `{}` | 1 |")
+
+	_, err = plugin.runQuery(context.Background(), "up{")
+	require.Error(t, err)
+}
+
+func TestForwardCommand(t *testing.T) {
+	now := time.Now().Truncate(time.Minute).UnixMilli()
+	db := createTestTSDB(t, now-time.Hour.Milliseconds(), now, labels.FromStrings(labels.MetricName, "up", "job", "prometheus"))
+
+	haConfig := &model.Config{ClusterSettings: model.ClusterSettings{Enable: model.NewBool(true)}}
+	collecting := &Plugin{
+		db:     db,
+		logger: log.NewNopLogger(),
+	}
+	collectingAPI := newKVStoreMock()
+	collectingAPI.On("LogDebug", mock.Anything, mock.Anything, mock.Anything).Maybe()
+	collecting.SetAPI(collectingAPI)
+
+	// the nodes share the KV store
+	api := newKVStoreMock()
+	api.On("GetConfig").Return(haConfig)
+	api.On("PublishPluginClusterEvent", mock.Anything, mock.Anything).Return(func(ev model.PluginClusterEvent, _ model.PluginClusterEventSendOptions) error {
+		collecting.OnPluginClusterEvent(nil, ev)
+		return nil
+	})
+	collectingAPI.On("KVSetWithExpiry", mock.Anything, mock.Anything, mock.Anything).Return(func(key string, value []byte, _ int64) *model.AppError {
+		_, appErr := api.KVCompareAndSet(key, nil, value)
+		return appErr
+	})
+
+	plugin := &Plugin{
+		logger: log.NewNopLogger(),
+	}
+	plugin.SetAPI(api)
+
+	text, err := plugin.runOnCollectingNode(context.Background(), commandRequest{Command: commandQuery, Query: `count(up{job="prometheus"})`})
+	require.NoError(t, err)
+	require.Contains(t, text, "| `{}` | 1 |")
+
+	_, err = plugin.runOnCollectingNode(context.Background(), commandRequest{Command: commandQuery, Query: "up{"})
+	require.Error(t, err)
+
+	// the collecting node runs the command
+	api.AssertNumberOfCalls(t, "PublishPluginClusterEvent", 2)
+}
@@ -0,0 +1,254 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/oklog/ulid"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+const (
+	remoteCompactionKey     = PluginName + "_remote_compaction"
+	remoteCompactionDirName = "compact"
+
+	// remoteCompactionInterval is the period of compacting the blocks in the file store.
+	remoteCompactionInterval = time.Hour
+	// compactedBlocksGracePeriod is how long the source blocks of a compaction are kept in the
+	// file store if the dump job timeout is disabled. The running dumps may still read them.
+	compactedBlocksGracePeriod = 6 * time.Hour
+)
+
+// compactRemote is called periodically by a cluster job, it merges the adjacent blocks in the
+// file store into the blocks of the configured range. The source blocks are deleted once the
+// running dumps can't be reading them anymore.
+func (p *Plugin) compactRemote() {
+	cfg, err := p.getConfiguration()
+	if err != nil {
+		p.API.LogError("could not get plugin configuration", "err", err)
+		return
+	}
+
+	ctx := context.TODO()
+	now := time.Now()
+
+	grace := p.jobTimeout()
+	if grace <= 0 {
+		grace = compactedBlocksGracePeriod
+	}
+
+	blockRange := time.Duration(*cfg.RemoteCompactionBlockHours) * time.Hour
+	// the blocks still in the local tsdb would be synced again once they are deleted from the
+	// file store, hence only the blocks older than the local retention are compacted.
+	before := now.Add(-time.Duration(*cfg.LocalRetentionDays)*24*time.Hour - blockRange)
+
+	remoteStorageDir := filepath.Join(pluginDataDir, PluginName, tsdbDirName)
+	dirs := []string{remoteStorageDir}
+	for _, resolution := range downsampleResolutions {
+		dirs = append(dirs, downsampleDir(remoteStorageDir, resolution))
+	}
+
+	for _, dir := range dirs {
+		// the sources of the previous compactions are deleted even if the compaction is disabled
+		if err = p.deleteCompactedBlocks(dir, now.Add(-grace)); err != nil {
+			p.API.LogError("could not delete the compacted blocks", "dir", dir, "err", err)
+		}
+
+		if blockRange == 0 {
+			continue
+		}
+
+		if err = p.compactBlocks(ctx, dir, blockRange.Milliseconds(), before.UnixMilli()); err != nil {
+			p.API.LogError("could not compact the blocks", "dir", dir, "err", err)
+			p.notify(fmt.Sprintf(":warning: The metrics could not be compacted in the file store: %s", err))
+			return
+		}
+	}
+}
+
+// compactBlocks merges the blocks under root within each window of the block range ending
+// before the given time into a single block.
+func (p *Plugin) compactBlocks(ctx context.Context, root string, blockRange, before int64) error {
+	blocks, err := p.listRemoteBlocks(root, math.MinInt64, math.MaxInt64)
+	if err != nil {
+		return fmt.Errorf("could not list the blocks: %w", err)
+	}
+
+	for _, group := range compactionGroups(withoutCompactedBlocks(blocks), blockRange, before) {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		if err = p.compactRemoteGroup(root, group, blockRange); err != nil {
+			// the group is retried in the next run
+			p.API.LogError("could not compact the blocks", "ulid", group[0].meta.ULID, "count", len(group), "err", err)
+		}
+	}
+
+	return nil
+}
+
+// compactionGroups groups the blocks by the windows of the block range they are within. Only
+// the windows ending before the given time and having more than one block are returned. The
+// blocks crossing the window boundaries are never compacted.
+func compactionGroups(blocks []remoteBlock, blockRange, before int64) [][]remoteBlock {
+	windows := make(map[int64][]remoteBlock)
+	for _, b := range blocks {
+		start := intervalStart(b.meta.MinTime, blockRange)
+		if b.meta.MaxTime > start+blockRange || start+blockRange > before {
+			continue
+		}
+		windows[start] = append(windows[start], b)
+	}
+
+	var groups [][]remoteBlock
+	for _, group := range windows {
+		if len(group) < 2 {
+			continue
+		}
+		sort.Slice(group, func(i, j int) bool {
+			return group[i].meta.MinTime < group[j].meta.MinTime
+		})
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i][0].meta.MinTime < groups[j][0].meta.MinTime
+	})
+
+	return groups
+}
+
+// compactRemoteGroup fetches the blocks from the file store, merges them and uploads the merged
+// block into root. The source blocks are deleted later on by deleteCompactedBlocks.
+func (p *Plugin) compactRemoteGroup(root string, group []remoteBlock, blockRange int64) error {
+	workDir := filepath.Join(remoteCompactionDirName, group[0].meta.ULID.String())
+	defer os.RemoveAll(workDir)
+
+	fetchDir := filepath.Join(workDir, "fetch")
+	dirs := make([]string, 0, len(group))
+	for _, b := range group {
+		if err := copyFromFileStore(fetchDir, b.path, b.root, p.fileBackend); err != nil {
+			return fmt.Errorf("could not fetch the block %s: %w", b.meta.ULID, err)
+		}
+		dirs = append(dirs, filepath.Join(fetchDir, filepath.Base(b.path)))
+	}
+
+	compactor, err := tsdb.NewLeveledCompactor(context.Background(), nil, p.logger, []int64{blockRange}, chunkenc.NewPool(), nil)
+	if err != nil {
+		return fmt.Errorf("could not create the compactor: %w", err)
+	}
+
+	outDir := filepath.Join(workDir, "out")
+	if err = os.MkdirAll(outDir, 0740); err != nil {
+		return err
+	}
+
+	id, err := compactor.Compact(outDir, dirs, nil)
+	if err != nil {
+		return fmt.Errorf("could not merge the blocks: %w", err)
+	}
+
+	if id == (ulid.ULID{}) {
+		// the blocks have no samples, there is nothing to keep
+		for _, b := range group {
+			if rErr := p.fileBackend.RemoveDirectory(b.path); rErr != nil {
+				p.API.LogWarn("unable to remove block from filestore", "err", rErr)
+			}
+		}
+		return nil
+	}
+
+	if err = p.uploadBlock(filepath.Join(outDir, id.String()), filepath.Join(root, id.String())); err != nil {
+		return fmt.Errorf("could not upload the merged block: %w", err)
+	}
+
+	p.API.LogInfo("Blocks merged in the filestore", "ulid", id, "count", len(group))
+
+	return nil
+}
+
+// deleteCompactedBlocks deletes the source blocks of the blocks merged before the given time.
+func (p *Plugin) deleteCompactedBlocks(root string, before time.Time) error {
+	blocks, err := p.listRemoteBlocks(root, math.MinInt64, math.MaxInt64)
+	if err != nil {
+		return err
+	}
+
+	byID := make(map[ulid.ULID]remoteBlock, len(blocks))
+	for _, b := range blocks {
+		byID[b.meta.ULID] = b
+	}
+
+	for _, b := range blocks {
+		// the ulid of a block has its creation time
+		if len(b.meta.Compaction.Parents) == 0 || ulid.Time(b.meta.ULID.Time()).After(before) {
+			continue
+		}
+
+		for _, parent := range b.meta.Compaction.Parents {
+			source, ok := byID[parent.ULID]
+			if !ok {
+				continue
+			}
+
+			p.API.LogInfo("Deleting compacted block from the filestore", "ulid", parent.ULID, "merged", b.meta.ULID)
+			if err = p.fileBackend.RemoveDirectory(source.path); err != nil {
+				p.API.LogWarn("unable to remove block from filestore", "err", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// withoutSourceBlocks removes the blocks those are among the sources of another block, e.g. the
+// source blocks kept until the grace period of the merged block ends. The blocks should be of
+// the same resolution, as the downsampled blocks have the sources of the raw blocks.
+func withoutSourceBlocks(blocks []remoteBlock) []remoteBlock {
+	merged := make(map[ulid.ULID]bool)
+	for _, b := range blocks {
+		for _, source := range b.meta.Compaction.Sources {
+			// the blocks not merged have themselves as the source
+			if source != b.meta.ULID {
+				merged[source] = true
+			}
+		}
+	}
+
+	result := make([]remoteBlock, 0, len(blocks))
+	for _, b := range blocks {
+		if !merged[b.meta.ULID] {
+			result = append(result, b)
+		}
+	}
+
+	return result
+}
+
+// withoutCompactedBlocks removes the blocks those are already merged into another block.
+func withoutCompactedBlocks(blocks []remoteBlock) []remoteBlock {
+	merged := make(map[ulid.ULID]bool)
+	for _, b := range blocks {
+		for _, parent := range b.meta.Compaction.Parents {
+			merged[parent.ULID] = true
+		}
+	}
+
+	result := make([]remoteBlock, 0, len(blocks))
+	for _, b := range blocks {
+		if !merged[b.meta.ULID] {
+			result = append(result, b)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"context"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/mattermost/mattermost/server/v8/platform/shared/filestore"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	pluginmocks "github.com/mattermost/mattermost-plugin-metrics/server/mocks/github.com/mattermost/mattermost/server/public/plugin"
+)
+
+func TestCompactionGroups(t *testing.T) {
+	hour := time.Hour.Milliseconds()
+	block := func(mint, maxt int64) remoteBlock {
+		return remoteBlock{meta: &tsdb.BlockMeta{MinTime: mint, MaxTime: maxt}}
+	}
+
+	blocks := []remoteBlock{
+		block(2*hour, 4*hour),
+		block(0, 2*hour),
+		block(4*hour, 6*hour),
+		// crosses the window boundary
+		block(22*hour, 26*hour),
+		// alone in its window
+		block(26*hour, 28*hour),
+		// the window has not ended yet
+		block(48*hour, 50*hour),
+		block(50*hour, 52*hour),
+	}
+
+	groups := compactionGroups(blocks, 24*hour, 60*hour)
+	require.Len(t, groups, 1)
+	require.Equal(t, []remoteBlock{blocks[1], blocks[0], blocks[2]}, groups[0])
+
+	groups = compactionGroups(blocks, 24*hour, 72*hour)
+	require.Len(t, groups, 2)
+	require.Equal(t, []remoteBlock{blocks[5], blocks[6]}, groups[1])
+}
+
+func TestCompactBlocks(t *testing.T) {
+	maxt := time.Now().Truncate(24 * time.Hour).UnixMilli()
+	mint := maxt - 24*time.Hour.Milliseconds()
+
+	lset := labels.FromStrings(labels.MetricName, "go_goroutines", "job", "prometheus")
+	db := createTestTSDB(t, mint, maxt-1, lset)
+
+	// the blocks are uploaded with the default block duration as the tsdb does
+	blocksDir := t.TempDir()
+	q, err := db.Querier(mint, maxt)
+	require.NoError(t, err)
+	defer q.Close()
+
+	err = rewriteTSDB(context.Background(), log.NewNopLogger(), q, blocksDir, rewriteOptions{
+		mint:          mint,
+		maxt:          maxt - 1,
+		blockDuration: tsdb.DefaultBlockDuration,
+	})
+	require.NoError(t, err)
+
+	fs, err := filestore.NewFileBackend(filestore.FileBackendSettings{
+		DriverName: "local",
+		Directory:  t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	remoteStorageDir := filepath.Join(pluginDataDir, PluginName, tsdbDirName)
+	require.NoError(t, copyDirectory(blocksDir, remoteStorageDir, fs.WriteFile))
+
+	api := &pluginmocks.MockAPI{}
+	api.On("LogInfo", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Maybe()
+
+	plugin := &Plugin{
+		fileBackend: fs,
+		logger:      log.NewNopLogger(),
+	}
+	plugin.SetAPI(api)
+
+	sources, err := plugin.listRemoteBlocks(remoteStorageDir, math.MinInt64, math.MaxInt64)
+	require.NoError(t, err)
+	require.Len(t, sources, 12)
+
+	require.NoError(t, plugin.compactBlocks(context.Background(), remoteStorageDir, 24*time.Hour.Milliseconds(), maxt))
+
+	// the sources are kept until the grace period passes, but they are not read anymore
+	blocks, err := plugin.listRemoteBlocks(remoteStorageDir, math.MinInt64, math.MaxInt64)
+	require.NoError(t, err)
+	require.Len(t, blocks, 13)
+
+	blocks = withoutCompactedBlocks(blocks)
+	require.Len(t, blocks, 1)
+	require.Equal(t, mint, blocks[0].meta.MinTime)
+	// the block writer ends the blocks right after their last sample
+	require.Equal(t, maxt-time.Minute.Milliseconds()+1, blocks[0].meta.MaxTime)
+	require.Equal(t, uint64(24*60), blocks[0].meta.Stats.NumSamples)
+
+	// the merged blocks are not compacted again
+	require.NoError(t, plugin.compactBlocks(context.Background(), remoteStorageDir, 24*time.Hour.Milliseconds(), maxt))
+	dirs, err := fs.ListDirectory(remoteStorageDir)
+	require.NoError(t, err)
+	require.Len(t, dirs, 13)
+
+	require.NoError(t, plugin.deleteCompactedBlocks(remoteStorageDir, time.Now().Add(-time.Hour)))
+	dirs, err = fs.ListDirectory(remoteStorageDir)
+	require.NoError(t, err)
+	require.Len(t, dirs, 13)
+
+	require.NoError(t, plugin.deleteCompactedBlocks(remoteStorageDir, time.Now().Add(time.Minute)))
+	dirs, err = fs.ListDirectory(remoteStorageDir)
+	require.NoError(t, err)
+	require.Equal(t, []string{blocks[0].path}, dirs)
+}
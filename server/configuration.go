@@ -46,8 +46,31 @@ type configuration struct {
 	ScrapeIntervalSeconds *int
 	// Scrape timeout tells scraper to give up on the poll for a single scrape attempt.
 	ScrapeTimeoutSeconds *int
-	// RetentionDurationDays defines the retention time for the tsdb blocks.
+	// RetentionDurationDays defines the retention time for the tsdb blocks in the remote filestore.
 	RetentionDurationDays *int
+	// RemoteRetentionMaxSizeMB is the maximum total size of the tsdb blocks in the remote
+	// filestore, the oldest blocks exceeding it are deleted. 0 means no limit.
+	RemoteRetentionMaxSizeMB *int
+	// RemoteCompactionBlockHours is the range of the blocks the adjacent blocks in the remote
+	// filestore are merged into. 0 disables the compaction.
+	RemoteCompactionBlockHours *int
+	// DownsampleTo5mAfterDays is the age of the blocks in the remote filestore to be downsampled
+	// to the 5 minutes resolution. 0 disables the downsampling to the 5 minutes resolution.
+	DownsampleTo5mAfterDays *int
+	// DownsampleTo1hAfterDays is the age of the blocks in the remote filestore to be downsampled
+	// to the 1 hour resolution. 0 disables the downsampling to the 1 hour resolution.
+	DownsampleTo1hAfterDays *int
+	// DownsampleRetentionDays defines the retention time for the blocks downsampled to the
+	// 5 minutes resolution.
+	DownsampleRetentionDays *int
+	// Downsample1hRetentionDays defines the retention time for the blocks downsampled to the
+	// 1 hour resolution.
+	Downsample1hRetentionDays *int
+	// LocalRetentionDays defines the retention time for the tsdb blocks in the local storage.
+	LocalRetentionDays *int
+	// LocalRetentionMaxSizeMB is the maximum size of the local tsdb, the oldest blocks
+	// exceeding it are deleted. 0 means no limit.
+	LocalRetentionMaxSizeMB *int
 	// FileStoreSyncPeriodMinutes is the period to sync local store with the remote filestore.
 	FileStoreSyncPeriodMinutes *int
 	// FileStoreCleanupPeriodMinutes is the period to run cleanup job in the filestore.
@@ -59,6 +82,29 @@ type configuration struct {
 	EnableNodeExporterTargets *bool
 	// NodeExporterPort is the port on which the node exporter is running (default 9100).
 	NodeExporterPort *int
+	// DumpEncryptionPublicKey is the age public key (age1...) the dumps are encrypted for.
+	DumpEncryptionPublicKey *string
+	// DumpEncryptionPassphrase is used to encrypt the dumps if no public key is set.
+	DumpEncryptionPassphrase *string
+	// AnonymizedLabels is the comma separated list of labels those values are hashed or
+	// redacted in the dumps requesting anonymization.
+	AnonymizedLabels *string
+	// DumpJobTimeoutMinutes is the maximum duration of a dump job, the in progress jobs
+	// exceeding it are marked as failed. 0 means no limit.
+	DumpJobTimeoutMinutes *int
+	// DumpRetentionMaxCount is the maximum number of the dumps to keep. 0 means no limit.
+	DumpRetentionMaxCount *int
+	// DumpRetentionMaxSizeMB is the maximum total size of the dumps to keep. 0 means no limit.
+	DumpRetentionMaxSizeMB *int
+	// DumpRetentionMaxAgeDays is the maximum age of the dumps to keep. 0 means no limit.
+	DumpRetentionMaxAgeDays *int
+	// ImportMaxSizeMB is the maximum size of an uploaded dump archive and of its extracted files.
+	ImportMaxSizeMB *int
+	// DumpDeliveryWebhookURL is the URL the dumps requesting the webhook delivery are posted to.
+	DumpDeliveryWebhookURL *string
+	// NotificationChannelID is the channel the bot posts the notifications to, e.g. the
+	// failed jobs and the scrape target outages.
+	NotificationChannelID *string
 }
 
 func (c *configuration) SetDefaults() {
@@ -95,6 +141,30 @@ func (c *configuration) SetDefaults() {
 	if c.RetentionDurationDays == nil {
 		c.RetentionDurationDays = model.NewInt(15)
 	}
+	if c.RemoteRetentionMaxSizeMB == nil {
+		c.RemoteRetentionMaxSizeMB = model.NewInt(0)
+	}
+	if c.RemoteCompactionBlockHours == nil {
+		c.RemoteCompactionBlockHours = model.NewInt(0)
+	}
+	if c.DownsampleTo5mAfterDays == nil {
+		c.DownsampleTo5mAfterDays = model.NewInt(0)
+	}
+	if c.DownsampleTo1hAfterDays == nil {
+		c.DownsampleTo1hAfterDays = model.NewInt(0)
+	}
+	if c.DownsampleRetentionDays == nil {
+		c.DownsampleRetentionDays = model.NewInt(90)
+	}
+	if c.Downsample1hRetentionDays == nil {
+		c.Downsample1hRetentionDays = model.NewInt(365)
+	}
+	if c.LocalRetentionDays == nil {
+		c.LocalRetentionDays = model.NewInt(3)
+	}
+	if c.LocalRetentionMaxSizeMB == nil {
+		c.LocalRetentionMaxSizeMB = model.NewInt(0)
+	}
 	if c.FileStoreSyncPeriodMinutes == nil {
 		c.FileStoreSyncPeriodMinutes = model.NewInt(60)
 	}
@@ -110,6 +180,36 @@ func (c *configuration) SetDefaults() {
 	if c.NodeExporterPort == nil {
 		c.NodeExporterPort = model.NewInt(9100)
 	}
+	if c.DumpEncryptionPublicKey == nil {
+		c.DumpEncryptionPublicKey = model.NewString("")
+	}
+	if c.DumpEncryptionPassphrase == nil {
+		c.DumpEncryptionPassphrase = model.NewString("")
+	}
+	if c.AnonymizedLabels == nil {
+		c.AnonymizedLabels = model.NewString("instance,hostname,host,ip,node,team_id,plugin_id")
+	}
+	if c.DumpJobTimeoutMinutes == nil {
+		c.DumpJobTimeoutMinutes = model.NewInt(360)
+	}
+	if c.DumpRetentionMaxCount == nil {
+		c.DumpRetentionMaxCount = model.NewInt(0)
+	}
+	if c.DumpRetentionMaxSizeMB == nil {
+		c.DumpRetentionMaxSizeMB = model.NewInt(0)
+	}
+	if c.DumpRetentionMaxAgeDays == nil {
+		c.DumpRetentionMaxAgeDays = model.NewInt(0)
+	}
+	if c.ImportMaxSizeMB == nil {
+		c.ImportMaxSizeMB = model.NewInt(2048)
+	}
+	if c.DumpDeliveryWebhookURL == nil {
+		c.DumpDeliveryWebhookURL = model.NewString("")
+	}
+	if c.NotificationChannelID == nil {
+		c.NotificationChannelID = model.NewString("")
+	}
 }
 
 func (c *configuration) IsValid() error {
@@ -122,12 +222,68 @@ func (c *configuration) IsValid() error {
 	if *c.BodySizeLimitBytes < 100 {
 		return errors.New("openmetrics body size is not realistic, should be greater than 100 bytes")
 	}
+	if *c.RetentionDurationDays < 1 || *c.LocalRetentionDays < 1 {
+		return errors.New("at least one day of metrics should be retained")
+	}
+	if *c.RemoteRetentionMaxSizeMB < 0 || *c.LocalRetentionMaxSizeMB < 0 {
+		return errors.New("retention size limits should not be negative")
+	}
+	if *c.RemoteCompactionBlockHours < 0 || *c.RemoteCompactionBlockHours%2 != 0 {
+		return errors.New("remote compaction block range should be a multiple of 2 hours")
+	}
+	if err := c.isValidDownsampling(); err != nil {
+		return err
+	}
 	if *c.SupportPacketMetricsDays < 1 {
 		return errors.New("at least one day of metrics should be included to the support packet")
 	}
 	if *c.NodeExporterPort < 1 || *c.NodeExporterPort > 65535 {
 		return errors.New("node exporter port should be between 1 and 65535")
 	}
+	if *c.DumpJobTimeoutMinutes < 0 {
+		return errors.New("dump job timeout should not be negative")
+	}
+	if *c.DumpRetentionMaxCount < 0 || *c.DumpRetentionMaxSizeMB < 0 || *c.DumpRetentionMaxAgeDays < 0 {
+		return errors.New("dump retention limits should not be negative")
+	}
+	if *c.ImportMaxSizeMB < 1 {
+		return errors.New("maximum import size should be at least 1 MB")
+	}
+	if *c.DumpDeliveryWebhookURL != "" && !model.IsValidHTTPURL(*c.DumpDeliveryWebhookURL) {
+		return errors.New("dump delivery webhook url should be a valid http url")
+	}
+	if *c.NotificationChannelID != "" && !model.IsValidId(*c.NotificationChannelID) {
+		return errors.New("notification channel id is not valid")
+	}
+	if _, err := dumpRecipient(c); err != nil {
+		return err
+	}
+	return nil
+}
+
+// isValidDownsampling validates that the blocks are downsampled before their source blocks
+// are deleted by the retention.
+func (c *configuration) isValidDownsampling() error {
+	to5m, to1h := *c.DownsampleTo5mAfterDays, *c.DownsampleTo1hAfterDays
+	if to5m < 0 || to1h < 0 {
+		return errors.New("downsampling ages should not be negative")
+	}
+	if *c.DownsampleRetentionDays < 1 || *c.Downsample1hRetentionDays < 1 {
+		return errors.New("at least one day of downsampled metrics should be retained")
+	}
+	if to5m > 0 && to5m >= *c.RetentionDurationDays {
+		return errors.New("metrics should be downsampled to 5 minutes resolution before they are deleted by the retention")
+	}
+	if to1h > 0 && to5m > 0 && (to1h <= to5m || to1h >= *c.DownsampleRetentionDays) {
+		return errors.New("metrics should be downsampled to 1 hour resolution after the 5 minutes resolution and before they are deleted by the retention")
+	}
+	if to1h > 0 && to5m == 0 && to1h >= *c.RetentionDurationDays {
+		return errors.New("metrics should be downsampled to 1 hour resolution before they are deleted by the retention")
+	}
+	if to1h > 0 && to1h >= *c.Downsample1hRetentionDays {
+		return errors.New("metrics downsampled to 1 hour resolution should be retained longer than their downsampling age")
+	}
+
 	return nil
 }
 
@@ -6,9 +6,12 @@ package main
 import (
 	"archive/tar"
 	"compress/gzip"
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"io/fs"
+	"math"
 	"os"
 	"path/filepath"
 	"strings"
@@ -71,7 +74,9 @@ func copyFile(srcFile, dstFile string, wr WriterFunc) error {
 	return nil
 }
 
-func copyFromFileStore(dst, src string, b filestore.FileBackend) error {
+// copyFromFileStore copies the file or directory from the file store into dst, the paths
+// are relative to root in the destination.
+func copyFromFileStore(dst, src, root string, b filestore.FileBackend) error {
 	if _, err := b.FileExists(src); err != nil {
 		return err
 	}
@@ -96,8 +101,7 @@ func copyFromFileStore(dst, src string, b filestore.FileBackend) error {
 			return err
 		}
 
-		trim := filepath.Join(pluginDataDir, PluginName, tsdbDirName)
-		fileDest := filepath.Join(dst, strings.TrimPrefix(src, trim))
+		fileDest := filepath.Join(dst, strings.TrimPrefix(src, root))
 
 		// create parent if there is no directory
 		err = os.MkdirAll(filepath.Dir(fileDest), 0740)
@@ -120,8 +124,7 @@ func copyFromFileStore(dst, src string, b filestore.FileBackend) error {
 
 	// it means this is a directory
 	if len(entries) > 0 {
-		trim := filepath.Join(pluginDataDir, PluginName, tsdbDirName)
-		fileDest := filepath.Join(dst, strings.TrimPrefix(src, trim))
+		fileDest := filepath.Join(dst, strings.TrimPrefix(src, root))
 
 		err := os.MkdirAll(fileDest, 0740)
 		if err != nil {
@@ -130,7 +133,7 @@ func copyFromFileStore(dst, src string, b filestore.FileBackend) error {
 	}
 
 	for _, entry := range entries {
-		err := copyFromFileStore(dst, entry, b)
+		err := copyFromFileStore(dst, entry, root, b)
 		if err != nil {
 			return err
 		}
@@ -139,56 +142,62 @@ func copyFromFileStore(dst, src string, b filestore.FileBackend) error {
 	return nil
 }
 
-func compressDirectory(sourceDir, compressedFile string) error {
-	// Create a new archive file
-	newZipFile, cErr := os.OpenFile(compressedFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
-	if cErr != nil {
-		return cErr
-	}
-	defer newZipFile.Close()
+// errArchiveTooLarge is returned if the extracted files of an archive exceed the size limit.
+var errArchiveTooLarge = errors.New("the extracted files exceed the size limit")
 
-	zr := gzip.NewWriter(newZipFile)
+// extractArchive extracts the regular files of a tar.gz archive, such as an uploaded dump,
+// into the destination directory. The extraction stops once the
+// extracted files exceed maxBytes or the context is canceled.
+func extractArchive(ctx context.Context, r io.Reader, dst string, maxBytes int64) error {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
 	defer zr.Close()
 
-	tw := tar.NewWriter(zr)
-	defer tw.Close()
-
-	// We will remove the srcDir from the file info later on
-	srcDir := sourceDir
+	var extracted int64
+	tr := tar.NewReader(zr)
+	for {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
 
-	// Walk through the directory and add files to the archive
-	wErr := filepath.Walk(sourceDir, func(file string, fi os.FileInfo, _ error) error {
-		if fi.Mode().IsDir() {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
 			return nil
+		} else if err != nil {
+			return err
 		}
 
-		newPath := file[len(srcDir):]
-
-		header, err := tar.FileInfoHeader(fi, newPath)
-		if err != nil {
-			return err
+		if header.Typeflag != tar.TypeReg {
+			continue
 		}
 
-		header.Name = newPath
-		if err = tw.WriteHeader(header); err != nil {
+		// cleaning the name as a rooted path ensures that the file stays under dst
+		fileDest := filepath.Join(dst, filepath.Clean("/"+header.Name))
+		if err = os.MkdirAll(filepath.Dir(fileDest), 0740); err != nil {
 			return err
 		}
 
-		data, err := os.Open(file)
+		f, err := os.OpenFile(fileDest, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
 		if err != nil {
 			return err
 		}
 
-		if _, err := io.Copy(tw, data); err != nil {
+		// the header size is not trusted, at most one byte over the limit is read
+		limit := maxBytes - extracted
+		if limit < math.MaxInt64 {
+			limit++
+		}
+		n, err := io.CopyN(f, tr, limit)
+		f.Close()
+		if err != nil && !errors.Is(err, io.EOF) {
 			return err
 		}
 
-		return nil
-	})
-
-	if wErr != nil {
-		return wErr
+		extracted += n
+		if extracted > maxBytes {
+			return fmt.Errorf("%w of %d bytes", errArchiveTooLarge, maxBytes)
+		}
 	}
-
-	return nil
 }
@@ -5,8 +5,12 @@ package main
 
 import (
 	"archive/tar"
+	"bytes"
 	"compress/gzip"
+	"context"
 	"io"
+	"io/fs"
+	"math"
 	"os"
 	"path/filepath"
 	"strings"
@@ -114,71 +118,107 @@ func TestCopy(t *testing.T) {
 	})
 }
 
-func TestCompressDirectory(t *testing.T) {
-	sampleFiles := []struct {
-		Name, Content string
-	}{
-		{"file1.txt", "This is file 1."},
-		{"subdir/file2.txt", "This is file 2."},
-	}
+// createTestArchive returns a tar.gz archive of the regular files under dir.
+func createTestArchive(t *testing.T, dir string) []byte {
+	t.Helper()
 
-	tempDir := filepath.Join(t.TempDir(), "compress")
-	for _, file := range sampleFiles {
-		filePath := filepath.Join(tempDir, file.Name)
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
 
-		err := os.MkdirAll(filepath.Dir(filePath), 0755)
-		require.NoError(t, err)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
 
-		err = os.WriteFile(filePath, []byte(file.Content), 0600)
-		require.NoError(t, err)
-	}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
 
-	zipFilePath := filepath.Join(t.TempDir(), "test.tar.gz")
-	err := compressDirectory(tempDir, zipFilePath)
+		err = tw.WriteHeader(&tar.Header{Name: filepath.ToSlash(rel), Typeflag: tar.TypeReg, Mode: 0600, Size: int64(len(b))})
+		if err != nil {
+			return err
+		}
+
+		_, err = tw.Write(b)
+		return err
+	})
 	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, zw.Close())
 
-	_, err = os.Stat(zipFilePath)
-	require.False(t, os.IsNotExist(err))
+	return buf.Bytes()
+}
 
-	zipFile, err := os.Open(zipFilePath)
+func TestExtractArchive(t *testing.T) {
+	srcDir := filepath.Join(t.TempDir(), "src")
+	err := os.MkdirAll(filepath.Join(srcDir, "subdir"), 0755)
 	require.NoError(t, err)
-	defer zipFile.Close()
 
-	gzFile, err := gzip.NewReader(zipFile)
+	err = os.WriteFile(filepath.Join(srcDir, "file1.txt"), []byte("This is file 1."), 0600)
 	require.NoError(t, err)
-	defer gzFile.Close()
 
-	tr := tar.NewReader(gzFile)
+	err = os.WriteFile(filepath.Join(srcDir, "subdir", "file2.txt"), []byte("This is file 2."), 0600)
+	require.NoError(t, err)
 
-	expectedContents := map[string]string{
-		"file1.txt":        "This is file 1.",
-		"subdir/file2.txt": "This is file 2.",
-	}
+	archive := createTestArchive(t, srcDir)
 
-	for {
-		header, err := tr.Next()
-		if err == io.EOF {
-			break // End of archive
-		}
+	t.Run("extract archive", func(t *testing.T) {
+		dst := t.TempDir()
+		err := extractArchive(context.Background(), bytes.NewReader(archive), dst, math.MaxInt64)
 		require.NoError(t, err)
 
-		// we only care about regular files in this test
-		if header.Typeflag != tar.TypeReg {
-			continue
-		}
+		b, err := os.ReadFile(filepath.Join(dst, "file1.txt"))
+		require.NoError(t, err)
+		require.Equal(t, "This is file 1.", string(b))
 
-		// the header has a trailing slash, gotta remove
-		fileName := strings.TrimPrefix(header.Name, "/")
-		content, ok := expectedContents[fileName]
-		require.True(t, ok)
-		expectedContent := []byte(content)
+		b, err = os.ReadFile(filepath.Join(dst, "subdir", "file2.txt"))
+		require.NoError(t, err)
+		require.Equal(t, "This is file 2.", string(b))
+	})
 
-		zippedContent, err := io.ReadAll(tr)
+	t.Run("don't extract outside of the destination", func(t *testing.T) {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(zw)
+		err := tw.WriteHeader(&tar.Header{Name: "../../escape.txt", Typeflag: tar.TypeReg, Mode: 0600, Size: 4})
+		require.NoError(t, err)
+		_, err = tw.Write([]byte("test"))
 		require.NoError(t, err)
-		require.Equal(t, expectedContent, zippedContent)
+		require.NoError(t, tw.Close())
+		require.NoError(t, zw.Close())
 
-		delete(expectedContents, fileName)
-	}
+		dst := filepath.Join(t.TempDir(), "a", "b")
+		err = extractArchive(context.Background(), &buf, dst, math.MaxInt64)
+		require.NoError(t, err)
 
-	require.Empty(t, expectedContents)
+		_, err = os.Stat(filepath.Join(dst, "escape.txt"))
+		require.NoError(t, err)
+	})
+
+	t.Run("invalid archive", func(t *testing.T) {
+		err := extractArchive(context.Background(), strings.NewReader("not an archive"), t.TempDir(), math.MaxInt64)
+		require.Error(t, err)
+	})
+
+	t.Run("size limit", func(t *testing.T) {
+		// the files have 30 bytes in total
+		err := extractArchive(context.Background(), bytes.NewReader(archive), t.TempDir(), 20)
+		require.ErrorIs(t, err, errArchiveTooLarge)
+
+		require.NoError(t, extractArchive(context.Background(), bytes.NewReader(archive), t.TempDir(), 30))
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := extractArchive(ctx, bytes.NewReader(archive), t.TempDir(), math.MaxInt64)
+		require.ErrorIs(t, err, context.Canceled)
+	})
 }
@@ -0,0 +1,203 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	DeliveryTypeChannel = "channel"
+	DeliveryTypeWebhook = "webhook"
+
+	// webhookDeliveryTimeout is the timeout of posting a dump to the webhook.
+	webhookDeliveryTimeout = 30 * time.Minute
+)
+
+// DumpDelivery is the target the dump is delivered to once the job finishes successfully.
+type DumpDelivery struct {
+	// Type is either DeliveryTypeChannel or DeliveryTypeWebhook.
+	Type string `json:"type"`
+	// ChannelID is the channel the dump is uploaded to. If UserID is set instead, the dump
+	// is uploaded to the direct message channel between the job creator, or the bot if the
+	// job has no creator, and the user.
+	ChannelID string `json:"channel_id,omitempty"`
+	UserID    string `json:"user_id,omitempty"`
+
+	// DeliveredAt is set once the dump is delivered, Error is set if the delivery fails.
+	DeliveredAt int64  `json:"delivered_at,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// validateDelivery validates the delivery target of a job created by the creator.
+func (p *Plugin) validateDelivery(d *DumpDelivery, creatorID string) error {
+	switch d.Type {
+	case DeliveryTypeChannel:
+		if d.ChannelID == "" && d.UserID == "" {
+			return errors.New("either channel_id or user_id should be set for the channel delivery")
+		}
+		if d.ChannelID != "" {
+			if !model.IsValidId(d.ChannelID) {
+				return errors.New("invalid channel_id")
+			}
+			if !p.API.HasPermissionToChannel(creatorID, d.ChannelID, model.PermissionUploadFile) {
+				return errors.New("no permission to upload files to the channel")
+			}
+		} else if !model.IsValidId(d.UserID) {
+			return errors.New("invalid user_id")
+		} else if _, appErr := p.API.GetUser(d.UserID); appErr != nil {
+			return fmt.Errorf("could not find the user %s: %w", d.UserID, appErr)
+		}
+	case DeliveryTypeWebhook:
+		cfg, err := p.getConfiguration()
+		if err != nil {
+			return err
+		}
+		if *cfg.DumpDeliveryWebhookURL == "" {
+			return errors.New("the dump delivery webhook is not configured")
+		}
+	default:
+		return errors.New("unknown delivery type")
+	}
+
+	return nil
+}
+
+// deliverDump delivers the dump of the successfully finished job to its delivery target.
+func (p *Plugin) deliverDump(ctx context.Context, job *DumpJob) error {
+	switch job.Delivery.Type {
+	case DeliveryTypeChannel:
+		return p.uploadDumpToChannel(job)
+	case DeliveryTypeWebhook:
+		return p.postDumpToWebhook(ctx, job)
+	}
+
+	return fmt.Errorf("unknown delivery type: %q", job.Delivery.Type)
+}
+
+func (p *Plugin) uploadDumpToChannel(job *DumpJob) error {
+	// the dump is posted as the creator, the scheduled jobs are posted as the bot
+	userID := job.CreatorID
+	if userID == "" {
+		userID = p.botID
+	}
+	if userID == "" {
+		return errors.New("the job has no user to post the dump as")
+	}
+
+	channelID := job.Delivery.ChannelID
+	if channelID == "" {
+		channel, appErr := p.API.GetDirectChannel(userID, job.Delivery.UserID)
+		if appErr != nil {
+			return fmt.Errorf("could not get the direct channel: %w", appErr)
+		}
+		channelID = channel.Id
+	}
+
+	if maxSize := p.API.GetConfig().FileSettings.MaxFileSize; maxSize != nil && job.Size > *maxSize {
+		return fmt.Errorf("the dump size %d exceeds the maximum file size %d", job.Size, *maxSize)
+	}
+
+	fr, err := p.fileBackend.Reader(job.DumpLocation)
+	if err != nil {
+		return fmt.Errorf("could not read the dump: %w", err)
+	}
+	defer fr.Close()
+
+	// the dump is streamed through an upload session rather than read into the memory
+	us, err := p.API.CreateUploadSession(&model.UploadSession{
+		Id:        model.NewId(),
+		Type:      model.UploadTypeAttachment,
+		CreateAt:  model.GetMillis(),
+		UserId:    userID,
+		ChannelId: channelID,
+		Filename:  filepath.Base(job.DumpLocation),
+		FileSize:  job.Size,
+	})
+	if err != nil {
+		return fmt.Errorf("could not create the upload session: %w", err)
+	}
+
+	fileInfo, err := p.API.UploadData(us, fr)
+	if err != nil {
+		return fmt.Errorf("could not upload the dump: %w", err)
+	} else if fileInfo == nil {
+		return errors.New("the dump upload is incomplete")
+	}
+
+	_, appErr := p.API.CreatePost(&model.Post{
+		UserId:    userID,
+		ChannelId: channelID,
+		Message:   dumpReadyMessage(job),
+		FileIds:   model.StringArray{fileInfo.Id},
+	})
+	if appErr != nil {
+		return fmt.Errorf("could not post the dump: %w", appErr)
+	}
+
+	return nil
+}
+
+func (p *Plugin) postDumpToWebhook(ctx context.Context, job *DumpJob) error {
+	cfg, err := p.getConfiguration()
+	if err != nil {
+		return err
+	}
+
+	webhookURL, err := url.Parse(*cfg.DumpDeliveryWebhookURL)
+	if err != nil {
+		return fmt.Errorf("invalid webhook url: %w", err)
+	}
+
+	fr, err := p.fileBackend.Reader(job.DumpLocation)
+	if err != nil {
+		return fmt.Errorf("could not read the dump: %w", err)
+	}
+	defer fr.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, webhookDeliveryTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL.String(), fr)
+	if err != nil {
+		return fmt.Errorf("could not create the webhook request: %w", err)
+	}
+	req.ContentLength = job.Size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(job.DumpLocation)))
+	req.Header.Set("X-Dump-Job-Id", job.ID)
+	req.Header.Set("X-Dump-Min-Time", strconv.FormatInt(job.MinT, 10))
+	req.Header.Set("X-Dump-Max-Time", strconv.FormatInt(job.MaxT, 10))
+	if job.Checksum != "" {
+		req.Header.Set("X-Checksum-Sha256", job.Checksum)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not post the dump to the webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("the webhook responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func dumpReadyMessage(job *DumpJob) string {
+	return fmt.Sprintf("The metrics dump from %s to %s is ready.",
+		time.UnixMilli(job.MinT).UTC().Format(time.RFC3339),
+		time.UnixMilli(job.MaxT).UTC().Format(time.RFC3339),
+	)
+}
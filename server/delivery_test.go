@@ -0,0 +1,135 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/v8/platform/shared/filestore"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	pluginmocks "github.com/mattermost/mattermost-plugin-metrics/server/mocks/github.com/mattermost/mattermost/server/public/plugin"
+)
+
+func TestPostDumpToWebhook(t *testing.T) {
+	fs, err := filestore.NewFileBackend(filestore.FileBackendSettings{
+		DriverName: "local",
+		Directory:  t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	content := []byte("dump contents")
+	location := "plugin-data/mattermost-plugin-metrics/dump/job1/tsdb_dump.tar.gz"
+	_, err = fs.WriteFile(bytes.NewReader(content), location)
+	require.NoError(t, err)
+
+	var received []byte
+	var header http.Header
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		received, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	cfg := new(configuration)
+	cfg.SetDefaults()
+	cfg.DumpDeliveryWebhookURL = model.NewString(server.URL)
+
+	plugin := &Plugin{
+		fileBackend: fs,
+	}
+	require.NoError(t, plugin.setConfiguration(cfg))
+
+	job := &DumpJob{
+		ID:           "job1",
+		DumpLocation: location,
+		Checksum:     "abc",
+		Size:         int64(len(content)),
+		Delivery:     &DumpDelivery{Type: DeliveryTypeWebhook},
+	}
+
+	require.NoError(t, plugin.deliverDump(context.Background(), job))
+	require.Equal(t, content, received)
+	require.Equal(t, "job1", header.Get("X-Dump-Job-Id"))
+	require.Equal(t, "abc", header.Get("X-Checksum-Sha256"))
+
+	status = http.StatusInternalServerError
+	require.Error(t, plugin.deliverDump(context.Background(), job))
+}
+
+func TestUploadDumpToChannel(t *testing.T) {
+	fs, err := filestore.NewFileBackend(filestore.FileBackendSettings{
+		DriverName: "local",
+		Directory:  t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	content := []byte("dump contents")
+	location := "plugin-data/mattermost-plugin-metrics/dump/job1/tsdb_dump.tar.gz"
+	_, err = fs.WriteFile(bytes.NewReader(content), location)
+	require.NoError(t, err)
+
+	api := &pluginmocks.MockAPI{}
+	api.On("GetConfig").Return(&model.Config{FileSettings: model.FileSettings{MaxFileSize: model.NewInt64(1024)}})
+	api.On("CreateUploadSession", mock.Anything).Return(func(us *model.UploadSession) (*model.UploadSession, error) {
+		return us, nil
+	})
+
+	var uploaded []byte
+	var session *model.UploadSession
+	api.On("UploadData", mock.Anything, mock.Anything).Return(func(us *model.UploadSession, rd io.Reader) (*model.FileInfo, error) {
+		session = us
+		// the dump is streamed rather than passed as a byte slice
+		uploaded, _ = io.ReadAll(rd)
+		return &model.FileInfo{Id: "file1"}, nil
+	})
+	api.On("CreatePost", mock.Anything).Return(&model.Post{}, nil)
+
+	plugin := &Plugin{
+		fileBackend: fs,
+	}
+	plugin.SetAPI(api)
+
+	job := &DumpJob{
+		ID:           "job1",
+		DumpLocation: location,
+		CreatorID:    "creator",
+		Size:         int64(len(content)),
+		Delivery:     &DumpDelivery{Type: DeliveryTypeChannel, ChannelID: "channel1"},
+	}
+
+	require.NoError(t, plugin.deliverDump(context.Background(), job))
+	require.Equal(t, content, uploaded)
+	require.Equal(t, "creator", session.UserId)
+	require.Equal(t, "channel1", session.ChannelId)
+	require.Equal(t, model.UploadTypeAttachment, session.Type)
+	require.Equal(t, int64(len(content)), session.FileSize)
+	api.AssertCalled(t, "CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.ChannelId == "channel1" && len(post.FileIds) == 1 && post.FileIds[0] == "file1"
+	}))
+}
+
+func TestValidateDelivery(t *testing.T) {
+	existing := model.NewId()
+	api := &pluginmocks.MockAPI{}
+	api.On("GetUser", existing).Return(&model.User{Id: existing}, nil)
+	api.On("GetUser", mock.Anything).Return(nil, model.NewAppError("GetUser", "app.user.missing_account.const", nil, "", http.StatusNotFound))
+
+	plugin := &Plugin{}
+	plugin.SetAPI(api)
+
+	require.NoError(t, plugin.validateDelivery(&DumpDelivery{Type: DeliveryTypeChannel, UserID: existing}, "creator"))
+	require.Error(t, plugin.validateDelivery(&DumpDelivery{Type: DeliveryTypeChannel, UserID: model.NewId()}, "creator"))
+	require.Error(t, plugin.validateDelivery(&DumpDelivery{Type: DeliveryTypeChannel, UserID: "invalid"}, "creator"))
+	require.Error(t, plugin.validateDelivery(&DumpDelivery{Type: DeliveryTypeChannel}, "creator"))
+}
@@ -0,0 +1,429 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	promModel "github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+const (
+	downsampleKey     = PluginName + "_downsample"
+	downsampleDirName = "downsample"
+
+	// downsampleInterval is the period of downsampling the blocks in the file store.
+	downsampleInterval = time.Hour
+
+	resolution5m = 5 * time.Minute
+	resolution1h = time.Hour
+
+	// the dumps of the ranges longer than these prefer the blocks of the resolution
+	resolution5mMinRange = 7 * 24 * time.Hour
+	resolution1hMinRange = 30 * 24 * time.Hour
+)
+
+// downsampleResolutions are the resolutions of the downsampled blocks from the finest to the coarsest.
+var downsampleResolutions = []time.Duration{resolution5m, resolution1h}
+
+// downsampleDir returns the directory of the blocks downsampled from the blocks under root,
+// the downsampled blocks are stored next to the raw blocks.
+func downsampleDir(root string, resolution time.Duration) string {
+	return filepath.Join(filepath.Dir(root), downsampleDirName, promModel.Duration(resolution).String())
+}
+
+// downsampleLevel downsamples the blocks older than the age to the resolution.
+type downsampleLevel struct {
+	resolution time.Duration
+	age        time.Duration
+}
+
+// downsampleLevels returns the enabled levels from the finest to the coarsest.
+func downsampleLevels(cfg *configuration) []downsampleLevel {
+	var levels []downsampleLevel
+	if *cfg.DownsampleTo5mAfterDays > 0 {
+		levels = append(levels, downsampleLevel{
+			resolution: resolution5m,
+			age:        time.Duration(*cfg.DownsampleTo5mAfterDays) * 24 * time.Hour,
+		})
+	}
+	if *cfg.DownsampleTo1hAfterDays > 0 {
+		levels = append(levels, downsampleLevel{
+			resolution: resolution1h,
+			age:        time.Duration(*cfg.DownsampleTo1hAfterDays) * 24 * time.Hour,
+		})
+	}
+
+	return levels
+}
+
+// preferredResolution returns the resolution preferred to read the range of the given length.
+func preferredResolution(length time.Duration) time.Duration {
+	switch {
+	case length >= resolution1hMinRange:
+		return resolution1h
+	case length >= resolution5mMinRange:
+		return resolution5m
+	}
+
+	return 0
+}
+
+// listResolutionBlocks lists the raw and the downsampled blocks of root overlapping with
+// [mint, maxt] and selects the blocks to read with the resolution preferred for the range.
+// It also returns the coarsest resolution of the selected blocks in milliseconds.
+func (p *Plugin) listResolutionBlocks(root string, mint, maxt int64) ([]remoteBlock, int64, error) {
+	blocks, err := p.listRemoteBlocks(root, mint, maxt)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for _, resolution := range downsampleResolutions {
+		downsampled, lErr := p.listRemoteBlocks(downsampleDir(root, resolution), mint, maxt)
+		if lErr != nil {
+			return nil, 0, lErr
+		}
+		for i := range downsampled {
+			downsampled[i].resolution = resolution.Milliseconds()
+		}
+		blocks = append(blocks, downsampled...)
+	}
+
+	blocks = selectResolution(withoutCompactedBlocks(blocks), preferredResolution(time.Duration(maxt-mint)*time.Millisecond).Milliseconds())
+
+	var resolution int64
+	for _, b := range blocks {
+		resolution = max(resolution, b.resolution)
+	}
+
+	return blocks, resolution, nil
+}
+
+// selectResolution selects the blocks to read among the blocks of different resolutions.
+// The coarsest blocks up to the preferred resolution are selected first, then the finer ones
+// and lastly the coarser ones. A block is skipped if its range is already covered by the
+// selected blocks, hence the older data only available in the coarser blocks is still read.
+func selectResolution(blocks []remoteBlock, preferred int64) []remoteBlock {
+	rank := func(b remoteBlock) int64 {
+		if b.resolution <= preferred {
+			return preferred - b.resolution
+		}
+		return b.resolution
+	}
+
+	sorted := make([]remoteBlock, len(blocks))
+	copy(sorted, blocks)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return rank(sorted[i]) < rank(sorted[j])
+	})
+
+	var selected []remoteBlock
+	for _, b := range sorted {
+		if isCovered(selected, b.meta.MinTime, b.meta.MaxTime) {
+			continue
+		}
+		selected = append(selected, b)
+	}
+
+	return selected
+}
+
+// isCovered returns whether the half-open range [mint, maxt) is covered by the blocks.
+func isCovered(blocks []remoteBlock, mint, maxt int64) bool {
+	ranges := make([][2]int64, 0, len(blocks))
+	for _, b := range blocks {
+		if b.meta.MaxTime > mint && b.meta.MinTime < maxt {
+			ranges = append(ranges, [2]int64{b.meta.MinTime, b.meta.MaxTime})
+		}
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i][0] < ranges[j][0]
+	})
+
+	covered := mint
+	for _, r := range ranges {
+		if r[0] > covered {
+			return false
+		}
+		covered = max(covered, r[1])
+		if covered >= maxt {
+			return true
+		}
+	}
+
+	return covered >= maxt
+}
+
+// downsampleRetentionDays returns the retention time of the blocks downsampled to the resolution,
+// the coarser blocks can be retained longer than the finer ones.
+func downsampleRetentionDays(cfg *configuration, resolution time.Duration) int {
+	if resolution == resolution1h {
+		return *cfg.Downsample1hRetentionDays
+	}
+	return *cfg.DownsampleRetentionDays
+}
+
+// downsampleRemote is called periodically by a cluster job, it downsamples the blocks older than
+// the configured ages in the file store and deletes the downsampled blocks exceeding the retention.
+func (p *Plugin) downsampleRemote() {
+	cfg, err := p.getConfiguration()
+	if err != nil {
+		p.API.LogError("could not get plugin configuration", "err", err)
+		return
+	}
+
+	ctx := context.TODO()
+	now := time.Now()
+	remoteStorageDir := filepath.Join(pluginDataDir, PluginName, tsdbDirName)
+
+	// the coarser blocks are downsampled from the finer ones if those are enabled
+	source := remoteStorageDir
+	for _, level := range downsampleLevels(cfg) {
+		target := downsampleDir(remoteStorageDir, level.resolution)
+		if err = p.downsampleBlocks(ctx, source, target, level.resolution, now.Add(-level.age)); err != nil {
+			p.API.LogError("could not downsample the blocks", "resolution", level.resolution.String(), "err", err)
+			p.notify(fmt.Sprintf(":warning: The metrics could not be downsampled to the %s resolution: %s", level.resolution, err))
+			return
+		}
+		source = target
+	}
+
+	// the downsampled blocks are kept even if the downsampling is disabled later on
+	for _, resolution := range downsampleResolutions {
+		if err = p.cleanupRemote(downsampleDir(remoteStorageDir, resolution), downsampleRetentionDays(cfg, resolution)); err != nil {
+			p.API.LogError("could not clean up the downsampled blocks", "resolution", resolution.String(), "err", err)
+		}
+	}
+}
+
+// downsampleBlocks downsamples the blocks under source ending before the given time into target.
+// The blocks those are already covered by the blocks in the target are skipped.
+func (p *Plugin) downsampleBlocks(ctx context.Context, source, target string, resolution time.Duration, before time.Time) error {
+	blocks, err := p.listRemoteBlocks(source, math.MinInt64, before.UnixMilli())
+	if err != nil {
+		return fmt.Errorf("could not list the blocks: %w", err)
+	}
+
+	downsampled, err := p.listRemoteBlocks(target, math.MinInt64, math.MaxInt64)
+	if err != nil {
+		return fmt.Errorf("could not list the downsampled blocks: %w", err)
+	}
+
+	for _, b := range withoutCompactedBlocks(blocks) {
+		if b.meta.MaxTime > before.UnixMilli() || isCovered(downsampled, b.meta.MinTime, b.meta.MaxTime) {
+			continue
+		}
+
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		meta, dErr := p.downsampleRemoteBlock(ctx, b, target, resolution)
+		if dErr != nil {
+			// the block is retried in the next run
+			p.API.LogError("could not downsample the block", "ulid", b.meta.ULID, "resolution", resolution.String(), "err", dErr)
+			continue
+		}
+		if meta == nil {
+			continue
+		}
+
+		p.API.LogInfo("Block downsampled", "ulid", b.meta.ULID, "downsampled", meta.ULID, "resolution", resolution.String())
+		downsampled = append(downsampled, remoteBlock{meta: meta})
+	}
+
+	return nil
+}
+
+// downsampleRemoteBlock fetches the block from the file store, downsamples it and uploads the
+// downsampled block into target. It returns nil if the block has no samples to downsample.
+func (p *Plugin) downsampleRemoteBlock(ctx context.Context, b remoteBlock, target string, resolution time.Duration) (*tsdb.BlockMeta, error) {
+	workDir := filepath.Join(downsampleDirName, b.meta.ULID.String())
+	defer os.RemoveAll(workDir)
+
+	fetchDir := filepath.Join(workDir, "fetch")
+	if err := copyFromFileStore(fetchDir, b.path, b.root, p.fileBackend); err != nil {
+		return nil, fmt.Errorf("could not fetch the block: %w", err)
+	}
+
+	block, err := tsdb.OpenBlock(p.logger, filepath.Join(fetchDir, filepath.Base(b.path)), nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not open the block: %w", err)
+	}
+	defer block.Close()
+
+	q, err := tsdb.NewBlockQuerier(block, b.meta.MinTime, b.meta.MaxTime)
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+
+	outDir := filepath.Join(workDir, "out")
+	meta, err := downsampleBlock(ctx, p.logger, q, outDir, b.meta, resolution.Milliseconds())
+	if err != nil || meta == nil {
+		return nil, err
+	}
+
+	if err = p.uploadBlock(filepath.Join(outDir, meta.ULID.String()), filepath.Join(target, meta.ULID.String())); err != nil {
+		return nil, fmt.Errorf("could not upload the downsampled block: %w", err)
+	}
+
+	return meta, nil
+}
+
+// downsampleBlock writes the samples of the source block read by the querier into a new block
+// under dst, keeping only the last sample of each series within each resolution interval. The
+// last sample keeps the counters and the histograms usable by the rate functions while the
+// gauges are sampled at the resolution. The downsampled block has the same range and sources
+// as the source block so that the covered ranges can be tracked. It returns nil if there is
+// no sample in the source block.
+func downsampleBlock(ctx context.Context, logger log.Logger, q storage.Querier, dst string, source *tsdb.BlockMeta, resolution int64) (meta *tsdb.BlockMeta, err error) {
+	w, err := tsdb.NewBlockWriter(logger, dst, source.MaxTime-source.MinTime)
+	if err != nil {
+		return nil, fmt.Errorf("could not create block writer: %w", err)
+	}
+	defer func() {
+		if cErr := w.Close(); cErr != nil && err == nil {
+			err = cErr
+		}
+	}()
+
+	set := q.Select(ctx, true, nil, labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".+"))
+
+	app := w.Appender(ctx)
+	samples := 0
+	appendSample := func(lset labels.Labels, s *downsampleSample) error {
+		var aErr error
+		switch s.vt {
+		case chunkenc.ValFloat:
+			_, aErr = app.Append(0, lset, s.t, s.f)
+		case chunkenc.ValHistogram:
+			_, aErr = app.AppendHistogram(0, lset, s.t, s.h, nil)
+		case chunkenc.ValFloatHistogram:
+			_, aErr = app.AppendHistogram(0, lset, s.t, nil, s.fh)
+		}
+		if aErr != nil {
+			return fmt.Errorf("could not append sample: %w", aErr)
+		}
+
+		samples++
+		if samples%rewriteCommitSize == 0 {
+			if aErr = app.Commit(); aErr != nil {
+				return fmt.Errorf("could not commit samples: %w", aErr)
+			}
+			app = w.Appender(ctx)
+		}
+		return nil
+	}
+
+	var it chunkenc.Iterator
+	for set.Next() {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		series := set.At()
+		lset := series.Labels()
+
+		// the last sample of the current interval is appended once the next interval begins
+		var last *downsampleSample
+		it = series.Iterator(it)
+		for vt := it.Next(); vt != chunkenc.ValNone; vt = it.Next() {
+			s := &downsampleSample{vt: vt}
+			switch vt {
+			case chunkenc.ValFloat:
+				s.t, s.f = it.At()
+			case chunkenc.ValHistogram:
+				s.t, s.h = it.AtHistogram()
+			case chunkenc.ValFloatHistogram:
+				s.t, s.fh = it.AtFloatHistogram()
+			}
+
+			if last != nil && intervalStart(last.t, resolution) != intervalStart(s.t, resolution) {
+				if err = appendSample(lset, last); err != nil {
+					return nil, err
+				}
+			}
+			last = s
+		}
+		if it.Err() != nil {
+			return nil, fmt.Errorf("could not iterate samples: %w", it.Err())
+		}
+
+		if last != nil {
+			if err = appendSample(lset, last); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if set.Err() != nil {
+		return nil, fmt.Errorf("could not select series: %w", set.Err())
+	}
+
+	if err = app.Commit(); err != nil {
+		return nil, fmt.Errorf("could not commit samples: %w", err)
+	}
+
+	id, err := w.Flush(ctx)
+	if errors.Is(err, tsdb.ErrNoSeriesAppended) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("could not flush block: %w", err)
+	}
+
+	return rewriteDownsampledMeta(filepath.Join(dst, id.String()), source)
+}
+
+type downsampleSample struct {
+	vt chunkenc.ValueType
+	t  int64
+	f  float64
+	h  *histogram.Histogram
+	fh *histogram.FloatHistogram
+}
+
+// intervalStart returns the start of the resolution interval containing t.
+func intervalStart(t, resolution int64) int64 {
+	return t - t%resolution
+}
+
+// rewriteDownsampledMeta sets the range and the sources of the downsampled block in blockDir
+// to the ones of the source block.
+func rewriteDownsampledMeta(blockDir string, source *tsdb.BlockMeta) (*tsdb.BlockMeta, error) {
+	meta, err := readBlockMeta(filepath.Join(blockDir, metaFileName), os.ReadFile)
+	if err != nil {
+		return nil, err
+	}
+
+	meta.MinTime = source.MinTime
+	meta.MaxTime = source.MaxTime
+	meta.Compaction.Level = source.Compaction.Level
+	meta.Compaction.Sources = append([]ulid.ULID(nil), source.Compaction.Sources...)
+
+	b, err := json.MarshalIndent(meta, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+
+	if err = os.WriteFile(filepath.Join(blockDir, metaFileName), b, 0600); err != nil {
+		return nil, fmt.Errorf("could not write the block meta: %w", err)
+	}
+
+	return meta, nil
+}
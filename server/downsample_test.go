@@ -0,0 +1,118 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownsampleBlock(t *testing.T) {
+	maxt := time.Now().Truncate(time.Hour).UnixMilli()
+	mint := maxt - 2*time.Hour.Milliseconds()
+
+	lset := labels.FromStrings(labels.MetricName, "go_goroutines", "job", "prometheus")
+	db := createTestTSDB(t, mint, maxt-1, lset)
+
+	q, err := db.Querier(mint, maxt)
+	require.NoError(t, err)
+	defer q.Close()
+
+	id := ulid.MustNew(ulid.Now(), rand.Reader)
+	source := &tsdb.BlockMeta{
+		ULID:    id,
+		MinTime: mint,
+		MaxTime: maxt,
+		Compaction: tsdb.BlockMetaCompaction{
+			Level:   1,
+			Sources: []ulid.ULID{id},
+		},
+	}
+
+	dst := t.TempDir()
+	meta, err := downsampleBlock(context.Background(), log.NewNopLogger(), q, dst, source, resolution5m.Milliseconds())
+	require.NoError(t, err)
+	require.NotNil(t, meta)
+	require.Equal(t, mint, meta.MinTime)
+	require.Equal(t, maxt, meta.MaxTime)
+	require.Equal(t, source.Compaction.Sources, meta.Compaction.Sources)
+
+	written, err := readBlockMeta(filepath.Join(dst, meta.ULID.String(), metaFileName), os.ReadFile)
+	require.NoError(t, err)
+	require.Equal(t, meta, written)
+
+	// the last sample of each 5 minutes interval is kept
+	samples := readTestSeries(t, dst)[lset.String()]
+	require.Len(t, samples, 24)
+	for i, ts := range samples {
+		require.Equal(t, mint+int64(i+1)*resolution5m.Milliseconds()-time.Minute.Milliseconds(), ts)
+	}
+}
+
+func TestSelectResolution(t *testing.T) {
+	day := 24 * time.Hour.Milliseconds()
+	block := func(name string, mint, maxt int64, resolution time.Duration) remoteBlock {
+		return remoteBlock{
+			path:       name,
+			meta:       &tsdb.BlockMeta{MinTime: mint, MaxTime: maxt},
+			resolution: resolution.Milliseconds(),
+		}
+	}
+
+	// the raw blocks are retained for the last 3 days, the 5m blocks cover the days 2 to 10
+	// and the 1h blocks cover the days 5 to 20.
+	blocks := []remoteBlock{
+		block("raw1", 0, day, 0),
+		block("raw2", day, 2*day, 0),
+		block("raw3", 2*day, 3*day, 0),
+		block("5m1", 2*day, 3*day, resolution5m),
+		block("5m2", 3*day, 10*day, resolution5m),
+		block("1h1", 5*day, 20*day, resolution1h),
+	}
+
+	paths := func(blocks []remoteBlock) []string {
+		var paths []string
+		for _, b := range blocks {
+			paths = append(paths, b.path)
+		}
+		return paths
+	}
+
+	require.ElementsMatch(t, []string{"raw1", "raw2", "raw3", "5m2", "1h1"}, paths(selectResolution(blocks, 0)))
+	require.ElementsMatch(t, []string{"raw1", "raw2", "5m1", "5m2", "1h1"}, paths(selectResolution(blocks, resolution5m.Milliseconds())))
+	require.ElementsMatch(t, []string{"raw1", "raw2", "5m1", "5m2", "1h1"}, paths(selectResolution(blocks, resolution1h.Milliseconds())))
+	require.ElementsMatch(t, []string{"raw1", "raw2", "5m1", "1h1", "1h2"}, paths(selectResolution(append(blocks, block("1h2", 3*day, 5*day, resolution1h)), resolution1h.Milliseconds())))
+}
+
+func TestDownsampleRetention(t *testing.T) {
+	cfg := &configuration{}
+	cfg.SetDefaults()
+	*cfg.RetentionDurationDays = 15
+	*cfg.DownsampleTo5mAfterDays = 7
+	*cfg.DownsampleTo1hAfterDays = 30
+	*cfg.DownsampleRetentionDays = 60
+	*cfg.Downsample1hRetentionDays = 400
+
+	// the 1h blocks are retained longer than the 5m ones
+	require.Equal(t, 60, downsampleRetentionDays(cfg, resolution5m))
+	require.Equal(t, 400, downsampleRetentionDays(cfg, resolution1h))
+	require.NoError(t, cfg.isValidDownsampling())
+
+	// the 1h blocks are deleted before they are downsampled
+	*cfg.Downsample1hRetentionDays = 30
+	require.Error(t, cfg.isValidDownsampling())
+
+	*cfg.Downsample1hRetentionDays = 0
+	require.Error(t, cfg.isValidDownsampling())
+}
@@ -6,10 +6,15 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"time"
 
+	"github.com/oklog/ulid"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
 	"github.com/prometheus/prometheus/tsdb"
 )
 
@@ -17,110 +22,315 @@ type Dump struct {
 	Path string
 	MinT int64
 	MaxT int64
+	// Checksum is the hex encoded SHA-256 checksum of the archive as stored in the file store.
+	Checksum  string
+	Encrypted bool
+	// Size is the size of the archive in bytes.
+	Size int64
+	// Resolution is the coarsest resolution of the downsampled blocks read, in milliseconds.
+	Resolution int64
 }
 
-func (p *Plugin) createDump(ctx context.Context, id string, min, max time.Time, remoteStorageDir string) (*Dump, error) {
-	// get the blocks if there is any block in the remote filestore
-	blocks, err := p.fileBackend.ListDirectory(remoteStorageDir)
+// createDump writes the requested samples into an archive in the file store. The blocks are
+// fetched from the file store and rewritten window by window, each rewritten block is streamed
+// into the archive which is uploaded while it's being written. Hence, only the blocks
+// overlapping with the current window are kept on the local disk. The dump is aborted once
+// the context is canceled.
+func (p *Plugin) createDump(ctx context.Context, job *DumpJob, remoteStorageDir string, progress *progressReporter) (*Dump, error) {
+	matcherSets, err := parseMatcherSets(job.Matchers)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := p.getConfiguration()
+	if err != nil {
+		return nil, err
+	}
+
+	relabel, err := newAnonymizer(job.Anonymization, parseAnonymizedLabels(*cfg.AnonymizedLabels))
+	if err != nil {
+		return nil, err
+	}
+
+	// the archive is encrypted before it leaves this node if the encryption is configured
+	recipient, err := dumpRecipient(cfg)
 	if err != nil {
 		return nil, err
-	} else if len(blocks) == 0 {
-		return nil, errors.New("no blocks in the remote storage")
 	}
 
 	// we generate everything under a new directory to avoid conflicts
 	// between simultaneous downloads
-	dumpDir := filepath.Join("dump", id, "data")
-	tempZipFile := filepath.Join(filepath.Dir(dumpDir), zipFileName)
-
-	var actualMin, actualMax time.Time
-
-	for _, b := range blocks {
-		// read block meta from the remote filestore and decide if they are older than the
-		// retention period. If they are within the retention period, copy the data
-		// from the file store. Blocks older than the retention period are not copied.
-		meta, rErr := readBlockMeta(filepath.Join(b, metaFileName), p.fileBackend.ReadFile)
-		if rErr != nil {
-			// we intentionally log with debug level here, file store returns wrapped errors
-			// and to not pollute the logs, we simply reducing the log level here.
-			p.API.LogDebug("unable to read meta file", "err", rErr)
-			continue
-		}
-
-		metaMax := time.UnixMilli(meta.MaxTime)
-		metaMin := time.UnixMilli(meta.MinTime)
-		if metaMax.Before(max) && metaMax.After(min) {
-			p.API.LogInfo("Fetching block from the filestore", "ulid", meta.ULID, "Max Time", max.String())
+	workDir := filepath.Join("dump", job.ID)
+	defer os.RemoveAll(workDir)
 
-			err = copyFromFileStore(dumpDir, b, p.fileBackend)
-			if err != nil {
-				p.API.LogError("Error during fetching the block", "ulid", meta.ULID, "err", err)
-			}
+	progress.setPhase(JobPhaseFetch)
+	fetcher, err := p.newBlockFetcher(ctx, job, filepath.Join(workDir, "fetch"), remoteStorageDir, true)
+	if err != nil {
+		return nil, err
+	}
+	defer fetcher.Close()
 
-			if metaMax.After(actualMax) {
-				actualMax = metaMax
-			}
+	fetcher.progress = progress
+	progress.setBlocksTotal(fetcher.blocksTotal())
 
-			if metaMin.Before(actualMin) || actualMin.IsZero() {
-				actualMin = metaMin
-			}
-		}
+	archiveName := zipFileName
+	if job.Format == DumpFormatOpenMetrics {
+		archiveName = openMetricsZipFileName
+	}
+	if recipient != nil {
+		archiveName += encryptedFileExt
 	}
+	location := filepath.Join(pluginDataDir, PluginName, "dump", job.ID, archiveName)
 
-	// In order to save up space, we increase the maximum block duration to 6 hours (default is 2)
-	// so that we can increase the compaction.
-	db, err := tsdb.Open(dumpDir, p.logger, nil, &tsdb.Options{
-		MinBlockDuration:           tsdb.DefaultBlockDuration,
-		MaxBlockDuration:           3 * tsdb.DefaultBlockDuration,
-		AllowOverlappingCompaction: true,
-	}, nil)
+	aw, err := p.newArchiveWriter(location, recipient, progress)
 	if err != nil {
 		return nil, err
 	}
 
-	// we should compact the tsdb to remove/merge overlapping blocks. Also the older blocks
-	// will be deleted but we didn't pull them in the first place anyway.
-	err = db.Compact(ctx)
+	dump, err := p.writeDump(ctx, job, fetcher, aw, workDir, matcherSets, relabel, fetcher.resolution, progress)
 	if err != nil {
+		aw.abort(err)
+		if rErr := p.fileBackend.RemoveFile(location); rErr != nil {
+			p.API.LogDebug("could not remove the incomplete dump", "err", rErr)
+		}
 		return nil, err
 	}
 
-	err = db.Close()
+	dump.Path = location
+	dump.Encrypted = recipient != nil
+	dump.Size = int64(aw.size)
+
+	return dump, nil
+}
+
+// writeDump rewrites the requested samples and writes them into the archive along with the
+// packet metadata and the manifest.
+func (p *Plugin) writeDump(ctx context.Context, job *DumpJob, queryable storage.Queryable, aw *archiveWriter, workDir string, matcherSets [][]*labels.Matcher, relabel func(labels.Labels) labels.Labels, resolution int64, progress *progressReporter) (*Dump, error) {
+	dumpDir := filepath.Join(workDir, "data")
+	progress.setPhase(JobPhaseCompact)
+
+	// the fetched blocks may contain samples outside of the requested range, hence we
+	// rewrite them with only the requested samples and series.
+	var blocks []ManifestBlock
+	skipped := 0
+	err := p.rewriteDump(ctx, queryable, dumpDir, matcherSets, relabel, job.MinT, job.MaxT, func(blockDir string, meta *tsdb.BlockMeta) error {
+		blocks = append(blocks, ManifestBlock{
+			ULID:       meta.ULID.String(),
+			MinTime:    meta.MinTime,
+			MaxTime:    meta.MaxTime,
+			NumSeries:  meta.Stats.NumSeries,
+			NumSamples: meta.Stats.NumSamples,
+		})
+
+		// the OpenMetrics text is rendered window by window, so that only the current
+		// block is kept locally.
+		if job.Format == DumpFormatOpenMetrics {
+			n, err := p.addOpenMetricsFile(ctx, aw, blockDir, filepath.Join(workDir, DumpFormatOpenMetrics), meta)
+			if err != nil {
+				return fmt.Errorf("could not add the OpenMetrics text of block %s to the archive: %w", meta.ULID, err)
+			}
+			skipped += n
+		} else if err := aw.addDirectory(blockDir, meta.ULID.String()); err != nil {
+			return fmt.Errorf("could not add block %s to the archive: %w", meta.ULID, err)
+		}
+		return os.RemoveAll(blockDir)
+	})
 	if err != nil {
 		return nil, err
 	}
 
+	if len(blocks) == 0 {
+		return nil, errors.New("no samples within the requested range")
+	}
+
+	progress.setPhase(JobPhaseCompress)
+
+	actualMin, actualMax := blocks[0].MinTime, blocks[0].MaxTime-1
+	for _, b := range blocks[1:] {
+		actualMin = min(actualMin, b.MinTime)
+		// block time ranges are half-open: [MinTime, MaxTime)
+		actualMax = max(actualMax, b.MaxTime-1)
+	}
+
+	if skipped > 0 {
+		p.API.LogWarn("Native histogram samples are not included in the OpenMetrics export", "skipped", skipped)
+	}
+
 	// Add plugin specific metadata
 	customMetadata := map[string]any{
-		"min": min.UnixMilli(),
-		"max": max.UnixMilli(),
+		"min": job.MinT,
+		"max": job.MaxT,
 	}
 
-	_, err = p.client.System.GeneratePacketMetadata(dumpDir, customMetadata)
-	if err != nil {
+	metadataDir := filepath.Join(workDir, "metadata")
+	if err = os.MkdirAll(metadataDir, 0740); err != nil {
 		return nil, err
 	}
 
-	err = compressDirectory(dumpDir, tempZipFile)
+	_, err = p.client.System.GeneratePacketMetadata(metadataDir, customMetadata)
 	if err != nil {
 		return nil, err
 	}
-	defer os.Remove(tempZipFile)
 
-	err = os.RemoveAll(dumpDir)
-	if err != nil {
+	if err = aw.addDirectory(metadataDir, ""); err != nil {
 		return nil, err
 	}
 
-	zipFileNameRemote := filepath.Join(pluginDataDir, PluginName, tempZipFile)
-	err = copyFile(tempZipFile, zipFileNameRemote, p.fileBackend.WriteFile)
+	progress.setPhase(JobPhaseUpload)
+	checksum, err := aw.close(&DumpManifest{
+		Version:       manifestVersion1,
+		CreateAt:      time.Now().UnixMilli(),
+		RequestedMinT: job.MinT,
+		RequestedMaxT: job.MaxT,
+		MinT:          actualMin,
+		MaxT:          actualMax,
+		Resolution:    resolution,
+		Blocks:        blocks,
+	})
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("could not upload the dump: %w", err)
 	}
 
 	return &Dump{
-		Path: zipFileNameRemote,
-		MinT: actualMin.UnixMilli(),
-		MaxT: actualMax.UnixMilli(),
+		MinT:       actualMin,
+		MaxT:       actualMax,
+		Checksum:   checksum,
+		Resolution: resolution,
+	}, nil
+}
+
+// querier returns a querier reading from the fetched blocks. If includeLocal is set, it also
+// reads from the local tsdb if this node is collecting the metrics, so that the samples in the
+// head and in the blocks not yet synced to the file store are also included. The returned
+// function should be called to release the querier.
+func (p *Plugin) querier(db storage.Queryable, mint, maxt int64, includeLocal bool) (storage.Querier, func(), error) {
+	q, err := db.Querier(mint, maxt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p.tsdbLock.RLock()
+	if includeLocal && p.db != nil {
+		localQuerier, lErr := p.db.Querier(mint, maxt)
+		if lErr != nil {
+			p.tsdbLock.RUnlock()
+			q.Close()
+			return nil, nil, lErr
+		}
+		// the local blocks might be synced already, the overlapping samples are deduplicated
+		q = storage.NewMergeQuerier([]storage.Querier{q, localQuerier}, nil, storage.ChainedSeriesMerge)
+	}
+
+	return q, func() {
+		q.Close()
+		p.tsdbLock.RUnlock()
 	}, nil
 }
+
+// rewriteDump writes the samples within [mint, maxt] of the series matching any of the matcher
+// sets into blocks under the dump directory. The series labels are relabeled if relabel is set.
+// The samples are processed in windows of the block duration, fn is called for each written
+// block once its window is done.
+func (p *Plugin) rewriteDump(ctx context.Context, queryable storage.Queryable, dumpDir string, matcherSets [][]*labels.Matcher, relabel func(labels.Labels) labels.Labels, mint, maxt int64, fn func(blockDir string, meta *tsdb.BlockMeta) error) error {
+	if err := os.MkdirAll(dumpDir, 0740); err != nil {
+		return err
+	}
+
+	opts := rewriteOptions{
+		matcherSets:   matcherSets,
+		mint:          mint,
+		maxt:          maxt,
+		blockDuration: 3 * tsdb.DefaultBlockDuration,
+		relabel:       relabel,
+	}
+
+	written := make(map[string]bool)
+	return forEachWindow(opts, func(window rewriteOptions) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		q, release, err := p.querier(queryable, window.mint, window.maxt, true)
+		if err != nil {
+			return err
+		}
+
+		err = rewriteWindow(ctx, p.logger, q, dumpDir, window)
+		release()
+		if err != nil {
+			return err
+		}
+
+		entries, err := os.ReadDir(dumpDir)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			if _, pErr := ulid.Parse(entry.Name()); pErr != nil || !entry.IsDir() || written[entry.Name()] {
+				continue
+			}
+			written[entry.Name()] = true
+
+			blockDir := filepath.Join(dumpDir, entry.Name())
+			meta, rErr := readBlockMeta(filepath.Join(blockDir, metaFileName), os.ReadFile)
+			if rErr != nil {
+				return rErr
+			}
+
+			if err = fn(blockDir, meta); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// addOpenMetricsFile renders the samples of the block in blockDir into an OpenMetrics text
+// file under dir and adds it to the archive. The file is named after the start of the block,
+// hence the files are sorted by time in the archive. It returns the number of skipped samples.
+func (p *Plugin) addOpenMetricsFile(ctx context.Context, aw *archiveWriter, blockDir, dir string, meta *tsdb.BlockMeta) (int, error) {
+	if err := os.MkdirAll(dir, 0740); err != nil {
+		return 0, err
+	}
+
+	block, err := tsdb.OpenBlock(p.logger, blockDir, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer block.Close()
+
+	// block time ranges are half-open: [MinTime, MaxTime)
+	q, err := tsdb.NewBlockQuerier(block, meta.MinTime, meta.MaxTime-1)
+	if err != nil {
+		return 0, err
+	}
+	defer q.Close()
+
+	name := openMetricsFileName(meta.MinTime)
+	path := filepath.Join(dir, name)
+	defer os.Remove(path)
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	skipped, err := writeOpenMetrics(ctx, q, f, meta.MinTime, meta.MaxTime-1)
+	if err != nil {
+		return 0, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	return skipped, aw.addFile(name, f, info.Size(), info.ModTime())
+}
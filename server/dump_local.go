@@ -0,0 +1,241 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/prometheus/prometheus/tsdb"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+)
+
+const (
+	clusterEventLocalData     = "local_data"
+	KVStoreLocalDataKeyPrefix = PluginName + "_local_data_"
+	localDataDirName          = "local"
+	localDataTimeout          = 5 * time.Minute
+	localDataPollInterval     = 2 * time.Second
+)
+
+// localDataRequest is sent by the node creating a dump to the node collecting the metrics,
+// so that the samples not yet synced to the file store are included to the dump.
+type localDataRequest struct {
+	JobID    string   `json:"job_id"`
+	MinT     int64    `json:"min_t"`
+	MaxT     int64    `json:"max_t"`
+	Matchers []string `json:"matchers"`
+}
+
+type localDataResult struct {
+	Error string `json:"error"`
+}
+
+func localDataRemoteDir(jobID string) string {
+	return filepath.Join(pluginDataDir, PluginName, "dump", jobID, localDataDirName)
+}
+
+// OnPluginClusterEvent is invoked when an intra-cluster plugin event is received.
+func (p *Plugin) OnPluginClusterEvent(_ *plugin.Context, ev model.PluginClusterEvent) {
+	switch ev.Id {
+	case clusterEventLocalData:
+		p.handleLocalDataEvent(ev.Data)
+	case clusterEventCancelJob:
+		p.handleCancelJobEvent(ev.Data)
+	case clusterEventCommand:
+		p.handleCommandEvent(ev.Data)
+	}
+}
+
+func (p *Plugin) handleLocalDataEvent(data []byte) {
+	var req localDataRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		p.API.LogError("could not unmarshal local data request", "err", err)
+		return
+	}
+
+	// the upload is registered while holding the tsdb lock, OnDeactivate closes closeChan
+	// with the lock held, hence no upload is started once it waits for the running ones.
+	p.tsdbLock.RLock()
+	collecting := p.db != nil && !p.isClosing()
+	if collecting {
+		p.waitGroup.Add(1)
+	}
+	closeChan := p.closeChan
+	p.tsdbLock.RUnlock()
+	if !collecting {
+		return
+	}
+
+	// the hook should return as soon as possible, we upload the data in the background. The
+	// upload is canceled once the plugin is deactivated.
+	go func() {
+		defer p.waitGroup.Done()
+
+		ctx, cancel := closeContext(closeChan)
+		defer cancel()
+
+		var result localDataResult
+		if err := p.uploadLocalData(ctx, req); err != nil {
+			p.API.LogError("could not upload local data", "job", req.JobID, "err", err)
+			result.Error = err.Error()
+		}
+
+		b, err := json.Marshal(result)
+		if err != nil {
+			p.API.LogError("could not marshal local data result", "err", err)
+			return
+		}
+
+		if appErr := p.API.KVSetWithExpiry(KVStoreLocalDataKeyPrefix+req.JobID, b, int64(time.Hour/time.Second)); appErr != nil {
+			p.API.LogError("could not store local data result", "err", appErr)
+		}
+	}()
+}
+
+// uploadLocalData writes the requested samples of the local tsdb those are not synced to the
+// file store yet into blocks and uploads them to the file store.
+func (p *Plugin) uploadLocalData(ctx context.Context, req localDataRequest) error {
+	matcherSets, err := parseMatcherSets(req.Matchers)
+	if err != nil {
+		return err
+	}
+
+	localDir := filepath.Join("dump", req.JobID, localDataDirName)
+	if err = os.MkdirAll(localDir, 0740); err != nil {
+		return err
+	}
+	defer os.RemoveAll(filepath.Dir(localDir))
+
+	err = func() error {
+		p.tsdbLock.RLock()
+		defer p.tsdbLock.RUnlock()
+
+		if p.db == nil {
+			return errors.New("local tsdb is not available")
+		}
+
+		// the synced samples are read from the file store by the node creating the dump
+		mint := max(req.MinT, p.lastSyncedTime(filepath.Join(pluginDataDir, PluginName, tsdbDirName)))
+		if mint > req.MaxT {
+			return nil
+		}
+
+		q, qErr := p.db.Querier(mint, req.MaxT)
+		if qErr != nil {
+			return qErr
+		}
+		defer q.Close()
+
+		return rewriteTSDB(ctx, p.logger, q, localDir, rewriteOptions{
+			matcherSets:   matcherSets,
+			mint:          mint,
+			maxt:          req.MaxT,
+			blockDuration: 3 * tsdb.DefaultBlockDuration,
+		})
+	}()
+	if err != nil {
+		return err
+	}
+
+	return copyDirectory(localDir, localDataRemoteDir(req.JobID), p.fileBackend.WriteFile)
+}
+
+// lastSyncedTime returns the end of the local blocks synced to the file store, the samples
+// before it are available in the file store. The blocks are synced from the oldest, a block
+// not synced yet ends the synced range. The tsdb lock should be held by the caller.
+func (p *Plugin) lastSyncedTime(remoteStorageDir string) int64 {
+	synced := int64(math.MinInt64)
+	for _, b := range p.db.Blocks() {
+		meta := b.Meta()
+		// meta.json is uploaded last, hence the block is completely uploaded if it exists
+		ok, err := p.fileBackend.FileExists(filepath.Join(remoteStorageDir, meta.ULID.String(), metaFileName))
+		if err != nil || !ok {
+			break
+		}
+		// block time ranges are half-open: [MinTime, MaxTime)
+		synced = max(synced, meta.MaxTime)
+	}
+
+	return synced
+}
+
+// requestLocalData requests the samples those are not synced to the file store yet from the
+// node collecting the metrics. It returns the directory in the file store the collecting node
+// uploaded the blocks to, or an empty string if the collecting node doesn't respond in time.
+// The directory should be removed with removeLocalData once the blocks are read.
+func (p *Plugin) requestLocalData(ctx context.Context, job *DumpJob) (string, error) {
+	key := KVStoreLocalDataKeyPrefix + job.ID
+	if appErr := p.API.KVDelete(key); appErr != nil {
+		return "", fmt.Errorf("could not reset local data result: %w", appErr)
+	}
+
+	b, err := json.Marshal(localDataRequest{
+		JobID:    job.ID,
+		MinT:     job.MinT,
+		MaxT:     job.MaxT,
+		Matchers: job.Matchers,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	err = p.API.PublishPluginClusterEvent(model.PluginClusterEvent{
+		Id:   clusterEventLocalData,
+		Data: b,
+	}, model.PluginClusterEventSendOptions{
+		SendType: model.PluginClusterEventSendTypeReliable,
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not publish local data request: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, localDataTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(localDataPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			p.API.LogWarn("Collecting node did not respond in time, the dump will not include the recent samples", "job", job.ID)
+			return "", nil
+		case <-ticker.C:
+		}
+
+		b, appErr := p.API.KVGet(key)
+		if appErr != nil {
+			return "", fmt.Errorf("could not retrieve local data result: %w", appErr)
+		} else if len(b) == 0 {
+			continue
+		}
+
+		var result localDataResult
+		if err = json.Unmarshal(b, &result); err != nil {
+			return "", fmt.Errorf("could not unmarshal local data result: %w", err)
+		}
+
+		p.API.KVDelete(key)
+		if result.Error != "" {
+			return "", fmt.Errorf("collecting node could not upload local data: %s", result.Error)
+		}
+
+		return localDataRemoteDir(job.ID), nil
+	}
+}
+
+func (p *Plugin) removeLocalData(remoteDir string) {
+	if err := p.fileBackend.RemoveDirectory(remoteDir); err != nil {
+		p.API.LogWarn("could not remove local data from the file store", "err", err)
+	}
+}
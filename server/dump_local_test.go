@@ -0,0 +1,118 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/mattermost/mattermost/server/v8/platform/shared/filestore"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/stretchr/testify/require"
+
+	pluginmocks "github.com/mattermost/mattermost-plugin-metrics/server/mocks/github.com/mattermost/mattermost/server/public/plugin"
+)
+
+func TestHandleLocalDataEventWhileDeactivating(t *testing.T) {
+	now := time.Now().Truncate(time.Minute).UnixMilli()
+	db := createTestTSDB(t, now-time.Hour.Milliseconds(), now, labels.FromStrings(labels.MetricName, "go_goroutines"))
+
+	// the mock fails the test if the upload result is stored
+	api := &pluginmocks.MockAPI{}
+	defer api.AssertExpectations(t)
+
+	plugin := &Plugin{
+		db:        db,
+		closeChan: make(chan bool),
+	}
+	plugin.SetAPI(api)
+
+	ctx, cancel := closeContext(plugin.closeChan)
+	defer cancel()
+	close(plugin.closeChan)
+
+	// the running uploads are canceled
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "the context is not canceled once the plugin is deactivated")
+	}
+
+	// no upload is started once the plugin is deactivating
+	b, err := json.Marshal(localDataRequest{JobID: "job", MinT: now - time.Hour.Milliseconds(), MaxT: now})
+	require.NoError(t, err)
+	plugin.handleLocalDataEvent(b)
+	plugin.waitGroup.Wait()
+}
+
+func TestUploadLocalData(t *testing.T) {
+	blockRange := 2 * time.Hour.Milliseconds()
+	maxt := time.Now().UnixMilli() / blockRange * blockRange
+	mint := maxt - 3*blockRange
+	lset := labels.FromStrings(labels.MetricName, "go_goroutines")
+
+	// the local tsdb has three persisted blocks
+	source := createTestTSDB(t, mint, maxt-1, lset)
+	q, err := source.Querier(mint, maxt)
+	require.NoError(t, err)
+	defer q.Close()
+
+	dbDir := t.TempDir()
+	require.NoError(t, rewriteTSDB(context.Background(), log.NewNopLogger(), q, dbDir, rewriteOptions{
+		mint:          mint,
+		maxt:          maxt - 1,
+		blockDuration: blockRange,
+	}))
+
+	db, err := tsdb.Open(dbDir, log.NewNopLogger(), nil, tsdb.DefaultOptions(), nil)
+	require.NoError(t, err)
+	defer db.Close()
+	blocks := db.Blocks()
+	require.Len(t, blocks, 3)
+
+	fs, err := filestore.NewFileBackend(filestore.FileBackendSettings{
+		DriverName: "local",
+		Directory:  t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	plugin := &Plugin{
+		db:          db,
+		fileBackend: fs,
+		logger:      log.NewNopLogger(),
+	}
+
+	remoteStorageDir := filepath.Join(pluginDataDir, PluginName, tsdbDirName)
+	markSynced := func(b *tsdb.Block) {
+		_, wErr := fs.WriteFile(bytes.NewReader([]byte("{}")), filepath.Join(remoteStorageDir, b.Meta().ULID.String(), metaFileName))
+		require.NoError(t, wErr)
+	}
+
+	// the blocks after the first block not synced are not counted
+	markSynced(blocks[0])
+	markSynced(blocks[2])
+	require.Equal(t, blocks[0].Meta().MaxTime, plugin.lastSyncedTime(remoteStorageDir))
+
+	// the work directory is removed by the upload, only its empty parent is left
+	t.Cleanup(func() { os.Remove("dump") })
+	require.NoError(t, plugin.uploadLocalData(context.Background(), localDataRequest{
+		JobID: "job",
+		MinT:  mint,
+		MaxT:  maxt,
+	}))
+
+	uploaded, err := plugin.listRemoteBlocks(localDataRemoteDir("job"), mint, maxt)
+	require.NoError(t, err)
+	require.NotEmpty(t, uploaded)
+	for _, b := range uploaded {
+		require.GreaterOrEqual(t, b.meta.MinTime, blocks[0].Meta().MaxTime)
+	}
+}
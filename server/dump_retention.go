@@ -0,0 +1,118 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/alecthomas/units"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	dumpRetentionKey = PluginName + "_dump_retention"
+
+	// dumpRetentionInterval is the period of applying the dump retention policy.
+	dumpRetentionInterval = time.Hour
+)
+
+// dumpRetentionPolicy limits the dumps kept in the file store, zero values mean no limit.
+type dumpRetentionPolicy struct {
+	maxCount int
+	maxBytes int64
+	maxAge   time.Duration
+}
+
+func (r dumpRetentionPolicy) isEnabled() bool {
+	return r.maxCount > 0 || r.maxBytes > 0 || r.maxAge > 0
+}
+
+func newDumpRetentionPolicy(cfg *configuration) dumpRetentionPolicy {
+	return dumpRetentionPolicy{
+		maxCount: *cfg.DumpRetentionMaxCount,
+		maxBytes: int64(*cfg.DumpRetentionMaxSizeMB) * 1024 * 1024,
+		maxAge:   time.Duration(*cfg.DumpRetentionMaxAgeDays) * 24 * time.Hour,
+	}
+}
+
+// expired returns the dumps exceeding the retention policy. The dumps should be sorted
+// newest first, the newest dumps are kept.
+func (r dumpRetentionPolicy) expired(dumps []*DumpJob, now time.Time) []*DumpJob {
+	var expired []*DumpJob
+	var totalBytes int64
+	for i, dump := range dumps {
+		totalBytes += dump.Size
+
+		switch {
+		case r.maxCount > 0 && i >= r.maxCount,
+			r.maxBytes > 0 && totalBytes > r.maxBytes,
+			r.maxAge > 0 && now.Sub(time.UnixMilli(dump.CreateAt)) > r.maxAge:
+			expired = append(expired, dump)
+		}
+	}
+
+	return expired
+}
+
+// applyDumpRetention is called periodically by a cluster job, it deletes the dumps exceeding
+// the retention policy from the file store.
+func (p *Plugin) applyDumpRetention() {
+	cfg, err := p.getConfiguration()
+	if err != nil {
+		p.API.LogError("could not get plugin configuration", "err", err)
+		return
+	}
+
+	policy := newDumpRetentionPolicy(cfg)
+	if !policy.isEnabled() {
+		return
+	}
+
+	ctx := context.TODO()
+	jobs, err := p.listAllJobs(ctx, JobFilter{
+		Statuses: []string{model.JobStatusSuccess},
+	})
+	if err != nil {
+		p.API.LogError("could not get jobs", "err", err)
+		return
+	}
+
+	dumps := make([]*DumpJob, 0, len(jobs))
+	for _, job := range jobs {
+		// the imported archives are not created by this server
+		if job.Type == JobTypeImport {
+			continue
+		}
+
+		// the size of the dumps created by the previous versions is not stored
+		if job.Size == 0 && job.DumpLocation != "" {
+			size, sErr := p.fileBackend.FileSize(job.DumpLocation)
+			if sErr != nil {
+				p.API.LogWarn("could not get the size of the dump", "id", job.ID, "err", sErr)
+			}
+			job.Size = size
+		}
+
+		dumps = append(dumps, job)
+	}
+
+	var deleted int
+	var deletedBytes int64
+	for _, job := range policy.expired(dumps, time.Now()) {
+		if err = p.DeleteJob(ctx, job.ID); err != nil {
+			p.API.LogError("could not delete the dump exceeding the retention", "id", job.ID, "err", err)
+			continue
+		}
+		p.API.LogInfo("Dump deleted by the retention policy", "id", job.ID, "create_at", job.CreateAt, "size", job.Size)
+		deleted++
+		deletedBytes += job.Size
+	}
+
+	if deleted > 0 {
+		p.notify(fmt.Sprintf("%d dumps of %s in total are deleted by the retention policy.", deleted, units.Base2Bytes(deletedBytes).Round(1)))
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDumpRetentionPolicy(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+
+	// newest first
+	dumps := []*DumpJob{
+		{ID: "1", CreateAt: now.Add(-day).UnixMilli(), Size: 100},
+		{ID: "2", CreateAt: now.Add(-2 * day).UnixMilli(), Size: 200},
+		{ID: "3", CreateAt: now.Add(-3 * day).UnixMilli(), Size: 300},
+		{ID: "4", CreateAt: now.Add(-4 * day).UnixMilli(), Size: 400},
+	}
+
+	ids := func(jobs []*DumpJob) []string {
+		var ids []string
+		for _, job := range jobs {
+			ids = append(ids, job.ID)
+		}
+		return ids
+	}
+
+	for _, tc := range []struct {
+		name     string
+		policy   dumpRetentionPolicy
+		expected []string
+	}{
+		{
+			name: "no limits",
+		},
+		{
+			name:     "max count",
+			policy:   dumpRetentionPolicy{maxCount: 3},
+			expected: []string{"4"},
+		},
+		{
+			name:     "max bytes",
+			policy:   dumpRetentionPolicy{maxBytes: 350},
+			expected: []string{"3", "4"},
+		},
+		{
+			name:     "max age",
+			policy:   dumpRetentionPolicy{maxAge: 2*day + time.Hour},
+			expected: []string{"3", "4"},
+		},
+		{
+			name:     "combined limits",
+			policy:   dumpRetentionPolicy{maxCount: 3, maxBytes: 1000, maxAge: day + time.Hour},
+			expected: []string{"2", "3", "4"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, ids(tc.policy.expired(dumps, now)))
+		})
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRewriteDump(t *testing.T) {
+	now := time.Now().Truncate(time.Minute).UnixMilli()
+	lset := labels.FromStrings(labels.MetricName, "go_goroutines", "job", "prometheus")
+
+	// the fetched blocks cover the older samples and the local head covers the recent ones
+	fetched := createTestTSDB(t, now-6*time.Hour.Milliseconds(), now-time.Hour.Milliseconds(), lset)
+	local := createTestTSDB(t, now-time.Hour.Milliseconds(), now, lset)
+
+	plugin := &Plugin{
+		logger: log.NewNopLogger(),
+		db:     local,
+	}
+
+	mint := now - 3*time.Hour.Milliseconds()
+	maxt := now - 30*time.Minute.Milliseconds()
+
+	dumpDir := filepath.Join(t.TempDir(), "data")
+	actualMin, actualMax := maxt, mint
+	err := plugin.rewriteDump(context.Background(), fetched, dumpDir, nil, nil, mint, maxt, func(_ string, meta *tsdb.BlockMeta) error {
+		actualMin = min(actualMin, meta.MinTime)
+		actualMax = max(actualMax, meta.MaxTime-1)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, mint, actualMin)
+	require.Equal(t, maxt, actualMax)
+
+	series := readTestSeries(t, dumpDir)
+	require.Len(t, series[lset.String()], 2*60+30+1)
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"filippo.io/age"
+)
+
+const (
+	encryptedFileExt = ".age"
+)
+
+// dumpRecipient returns the age recipient the dumps are encrypted for. The public key takes
+// precedence over the passphrase, nil is returned if the encryption is not configured.
+func dumpRecipient(cfg *configuration) (age.Recipient, error) {
+	if key := strings.TrimSpace(*cfg.DumpEncryptionPublicKey); key != "" {
+		recipient, err := age.ParseX25519Recipient(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dump encryption public key: %w", err)
+		}
+		return recipient, nil
+	}
+
+	if passphrase := *cfg.DumpEncryptionPassphrase; passphrase != "" {
+		return age.NewScryptRecipient(passphrase)
+	}
+
+	return nil, nil
+}
+
+// dumpIdentity returns the identity to decrypt the dumps with. Only the dumps encrypted with
+// a passphrase can be decrypted by the plugin, nil is returned otherwise.
+func dumpIdentity(cfg *configuration) (age.Identity, error) {
+	if strings.TrimSpace(*cfg.DumpEncryptionPublicKey) != "" || *cfg.DumpEncryptionPassphrase == "" {
+		return nil, nil
+	}
+
+	return age.NewScryptIdentity(*cfg.DumpEncryptionPassphrase)
+}
+
+// verifyEncryptedArchive computes the checksum of the encrypted archive. If the plugin is able
+// to decrypt the archive, its contents are also verified against the manifest.
+func (p *Plugin) verifyEncryptedArchive(r io.Reader) (*ManifestVerification, error) {
+	cfg, err := p.getConfiguration()
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := dumpIdentity(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.New()
+	tr := io.TeeReader(r, h)
+
+	result := &ManifestVerification{
+		// without the manifest, the checksum is the only thing to verify
+		Valid: true,
+	}
+	if identity != nil {
+		dr, dErr := age.Decrypt(tr, identity)
+		if dErr != nil {
+			result = &ManifestVerification{Error: fmt.Sprintf("could not decrypt the archive: %s", dErr)}
+		} else if result, err = verifyArchive(dr); err != nil {
+			result = &ManifestVerification{Error: err.Error()}
+		}
+	}
+
+	if _, err = io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	result.Checksum = hex.EncodeToString(h.Sum(nil))
+
+	return result, nil
+}
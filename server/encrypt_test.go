@@ -0,0 +1,107 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/v8/platform/shared/filestore"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptArchive(t *testing.T) {
+	fs, err := filestore.NewFileBackend(filestore.FileBackendSettings{
+		DriverName: "local",
+		Directory:  t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	writeArchive := func(t *testing.T, cfg *configuration) (*Plugin, string, string) {
+		plugin := &Plugin{
+			fileBackend:   fs,
+			configuration: cfg,
+		}
+
+		recipient, err := dumpRecipient(cfg)
+		require.NoError(t, err)
+
+		location := model.NewId() + encryptedFileExt
+		aw, err := plugin.newArchiveWriter(location, recipient, nil)
+		require.NoError(t, err)
+
+		err = aw.addFile("metrics.om.txt", strings.NewReader("# EOF\n"), 6, time.Now())
+		require.NoError(t, err)
+
+		checksum, err := aw.close(&DumpManifest{Version: manifestVersion1})
+		require.NoError(t, err)
+
+		return plugin, location, checksum
+	}
+
+	t.Run("public key", func(t *testing.T) {
+		identity, err := age.GenerateX25519Identity()
+		require.NoError(t, err)
+
+		cfg := &configuration{}
+		cfg.SetDefaults()
+		cfg.DumpEncryptionPublicKey = model.NewString(identity.Recipient().String())
+		cfg.DumpEncryptionPassphrase = model.NewString("ignored")
+
+		plugin, location, _ := writeArchive(t, cfg)
+
+		b, err := fs.ReadFile(location)
+		require.NoError(t, err)
+
+		r, err := age.Decrypt(bytes.NewReader(b), identity)
+		require.NoError(t, err)
+
+		result, err := verifyArchive(r)
+		require.NoError(t, err)
+		require.True(t, result.Valid, result)
+
+		// the plugin can't decrypt the dumps encrypted with a public key
+		pluginIdentity, err := dumpIdentity(plugin.configuration)
+		require.NoError(t, err)
+		require.Nil(t, pluginIdentity)
+	})
+
+	t.Run("passphrase", func(t *testing.T) {
+		cfg := &configuration{}
+		cfg.SetDefaults()
+		cfg.DumpEncryptionPassphrase = model.NewString("secret")
+
+		plugin, location, checksum := writeArchive(t, cfg)
+
+		fr, err := fs.Reader(location)
+		require.NoError(t, err)
+		defer fr.Close()
+
+		result, err := plugin.verifyEncryptedArchive(fr)
+		require.NoError(t, err)
+		require.True(t, result.Valid, result)
+		require.Equal(t, checksum, result.Checksum)
+	})
+
+	t.Run("not configured", func(t *testing.T) {
+		cfg := &configuration{}
+		cfg.SetDefaults()
+
+		recipient, err := dumpRecipient(cfg)
+		require.NoError(t, err)
+		require.Nil(t, recipient)
+	})
+
+	t.Run("invalid public key", func(t *testing.T) {
+		cfg := &configuration{}
+		cfg.SetDefaults()
+		cfg.DumpEncryptionPublicKey = model.NewString("age1invalid")
+
+		require.Error(t, cfg.IsValid())
+	})
+}
@@ -0,0 +1,224 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	ExportFormatCSV     = "csv"
+	ExportFormatParquet = "parquet"
+
+	// exportBatchSize is the number of rows buffered before writing to the parquet writer.
+	exportBatchSize = 1000
+)
+
+var exportContentTypes = map[string]string{
+	ExportFormatCSV:     "text/csv",
+	ExportFormatParquet: "application/vnd.apache.parquet",
+}
+
+// exportRow is a single sample of a series in the exported table.
+type exportRow struct {
+	Timestamp int64   `parquet:"timestamp,timestamp(millisecond)"`
+	Metric    string  `parquet:"metric,dict"`
+	Labels    string  `parquet:"labels,dict"`
+	Value     float64 `parquet:"value"`
+}
+
+func isValidExportFormat(format string) bool {
+	_, ok := exportContentTypes[format]
+	return ok
+}
+
+// Export writes the float samples of the series matching any of the selectors within
+// [mint, maxt] as a table in the given format. Native histograms are not exported.
+// If importID is set, the samples are read from the imported dump instead of the
+// collected metrics. The blocks are fetched from the file store window by window as the
+// dumps do, so that only the blocks overlapping with the current window are kept locally.
+func (p *Plugin) Export(ctx context.Context, format string, mint, maxt int64, selectors []string, importID string, w io.Writer) error {
+	matcherSets, err := parseMatcherSets(selectors)
+	if err != nil {
+		return err
+	}
+
+	// the export uses the same blocks as the dumps, so we use a dump job to fetch them.
+	job := &DumpJob{
+		ID:       model.NewId(),
+		MinT:     mint,
+		MaxT:     maxt,
+		Matchers: selectors,
+	}
+
+	exportDir := filepath.Join("export", job.ID)
+	defer os.RemoveAll(exportDir)
+
+	remoteStorageDir := filepath.Join(pluginDataDir, PluginName, tsdbDirName)
+	if importID != "" {
+		remoteStorageDir = filepath.Join(importDir(importID), tsdbDirName)
+	}
+
+	// the local data of another collecting node is not requested, it would hold the export
+	// request for minutes.
+	fetcher, err := p.newBlockFetcher(ctx, job, filepath.Join(exportDir, "fetch"), remoteStorageDir, false)
+	if err != nil {
+		return err
+	}
+	defer fetcher.Close()
+
+	tw := newTableWriter(format, w)
+	opts := rewriteOptions{
+		mint:          mint,
+		maxt:          maxt,
+		blockDuration: 3 * tsdb.DefaultBlockDuration,
+	}
+	err = forEachWindow(opts, func(window rewriteOptions) error {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		// the samples not synced to the file store yet are included only if this node is
+		// collecting them. The imported dumps are read-only snapshots, they are never merged
+		// with the local data.
+		q, release, err := p.querier(fetcher, window.mint, window.maxt, importID == "")
+		if err != nil {
+			return err
+		}
+		defer release()
+
+		set := selectSeries(ctx, q, matcherSets, window.mint, window.maxt)
+		return forEachSample(set, window.mint, window.maxt, tw.writeRow)
+	})
+	if err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// forEachSample calls fn for each float sample of the series within [mint, maxt].
+func forEachSample(set storage.SeriesSet, mint, maxt int64, fn func(lset labels.Labels, t int64, v float64) error) error {
+	var it chunkenc.Iterator
+	for set.Next() {
+		series := set.At()
+		lset := series.Labels()
+
+		it = series.Iterator(it)
+		for vt := it.Next(); vt != chunkenc.ValNone; vt = it.Next() {
+			if vt != chunkenc.ValFloat {
+				continue
+			}
+
+			t, v := it.At()
+			if t < mint || t > maxt {
+				continue
+			}
+
+			if err := fn(lset, t, v); err != nil {
+				return err
+			}
+		}
+		if it.Err() != nil {
+			return it.Err()
+		}
+	}
+
+	return set.Err()
+}
+
+// tableWriter writes the samples as the rows of a table.
+type tableWriter interface {
+	writeRow(lset labels.Labels, t int64, v float64) error
+	Close() error
+}
+
+func newTableWriter(format string, w io.Writer) tableWriter {
+	if format == ExportFormatParquet {
+		return &parquetWriter{
+			pw:   parquet.NewGenericWriter[exportRow](w),
+			rows: make([]exportRow, 0, exportBatchSize),
+		}
+	}
+
+	return newCSVWriter(w)
+}
+
+type csvWriter struct {
+	cw  *csv.Writer
+	err error
+}
+
+func newCSVWriter(w io.Writer) *csvWriter {
+	cw := csv.NewWriter(w)
+	return &csvWriter{
+		cw:  cw,
+		err: cw.Write([]string{"timestamp", "metric", "labels", "value"}),
+	}
+}
+
+func (w *csvWriter) writeRow(lset labels.Labels, t int64, v float64) error {
+	if w.err != nil {
+		return w.err
+	}
+
+	return w.cw.Write([]string{
+		time.UnixMilli(t).UTC().Format("2006-01-02T15:04:05.000Z07:00"),
+		lset.Get(labels.MetricName),
+		formatOpenMetricsLabels(lset),
+		strconv.FormatFloat(v, 'g', -1, 64),
+	})
+}
+
+func (w *csvWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+
+	w.cw.Flush()
+	return w.cw.Error()
+}
+
+type parquetWriter struct {
+	pw   *parquet.GenericWriter[exportRow]
+	rows []exportRow
+}
+
+func (w *parquetWriter) writeRow(lset labels.Labels, t int64, v float64) error {
+	w.rows = append(w.rows, exportRow{
+		Timestamp: t,
+		Metric:    lset.Get(labels.MetricName),
+		Labels:    formatOpenMetricsLabels(lset),
+		Value:     v,
+	})
+	if len(w.rows) < exportBatchSize {
+		return nil
+	}
+
+	_, err := w.pw.Write(w.rows)
+	w.rows = w.rows[:0]
+	return err
+}
+
+func (w *parquetWriter) Close() error {
+	if _, err := w.pw.Write(w.rows); err != nil {
+		return err
+	}
+
+	return w.pw.Close()
+}
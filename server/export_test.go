@@ -0,0 +1,120 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/v8/platform/shared/filestore"
+	"github.com/parquet-go/parquet-go"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	pluginmocks "github.com/mattermost/mattermost-plugin-metrics/server/mocks/github.com/mattermost/mattermost/server/public/plugin"
+)
+
+func TestExportTable(t *testing.T) {
+	mint := int64(1700000000000)
+	maxt := mint + 120000
+
+	goroutines := labels.FromStrings(labels.MetricName, "go_goroutines", "job", "prometheus")
+	sessions := labels.FromStrings(labels.MetricName, "rtc_sessions", "job", "calls")
+	db := createTestTSDB(t, mint, maxt, goroutines, sessions)
+
+	q, err := db.Querier(mint, maxt)
+	require.NoError(t, err)
+	defer q.Close()
+
+	matcherSets, err := parseMatcherSets([]string{`{job="calls"}`})
+	require.NoError(t, err)
+
+	t.Run("csv", func(t *testing.T) {
+		var buf bytes.Buffer
+		tw := newTableWriter(ExportFormatCSV, &buf)
+		err := forEachSample(selectSeries(context.Background(), q, matcherSets, mint, maxt), mint, mint+60000, tw.writeRow)
+		require.NoError(t, err)
+		require.NoError(t, tw.Close())
+
+		expected := `timestamp,metric,labels,value
+2023-11-14T22:13:20.000Z,rtc_sessions,"{job=""calls""}",1.7e+12
+2023-11-14T22:14:20.000Z,rtc_sessions,"{job=""calls""}",1.70000006e+12
+`
+		require.Equal(t, expected, buf.String())
+	})
+
+	t.Run("parquet", func(t *testing.T) {
+		var buf bytes.Buffer
+		tw := newTableWriter(ExportFormatParquet, &buf)
+		err := forEachSample(selectSeries(context.Background(), q, nil, mint, maxt), mint, maxt, tw.writeRow)
+		require.NoError(t, err)
+		require.NoError(t, tw.Close())
+
+		rows, err := parquet.Read[exportRow](bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		require.NoError(t, err)
+		require.Len(t, rows, 6)
+		require.Equal(t, exportRow{
+			Timestamp: mint,
+			Metric:    "go_goroutines",
+			Labels:    `{job="prometheus"}`,
+			Value:     float64(mint),
+		}, rows[0])
+	})
+}
+
+func TestExport(t *testing.T) {
+	now := time.Now().Truncate(time.Minute).UnixMilli()
+	lset := labels.FromStrings(labels.MetricName, "go_goroutines", "job", "prometheus")
+	db := createTestTSDB(t, now-12*time.Hour.Milliseconds(), now, lset)
+
+	blocksDir := t.TempDir()
+	q, err := db.Querier(now-12*time.Hour.Milliseconds(), now)
+	require.NoError(t, err)
+	defer q.Close()
+
+	err = rewriteTSDB(context.Background(), log.NewNopLogger(), q, blocksDir, rewriteOptions{
+		mint:          now - 12*time.Hour.Milliseconds(),
+		maxt:          now,
+		blockDuration: tsdb.DefaultBlockDuration,
+	})
+	require.NoError(t, err)
+
+	fs, err := filestore.NewFileBackend(filestore.FileBackendSettings{
+		DriverName: "local",
+		Directory:  t.TempDir(),
+	})
+	require.NoError(t, err)
+	require.NoError(t, copyDirectory(blocksDir, filepath.Join(pluginDataDir, PluginName, tsdbDirName), fs.WriteFile))
+
+	api := &pluginmocks.MockAPI{}
+	api.On("GetConfig").Return(&model.Config{}).Maybe()
+	api.On("LogInfo", mock.Anything, mock.Anything, mock.Anything).Maybe()
+
+	plugin := &Plugin{
+		fileBackend: fs,
+		logger:      log.NewNopLogger(),
+	}
+	plugin.SetAPI(api)
+
+	// the range spans several windows, every sample is written once
+	mint := now - 10*time.Hour.Milliseconds()
+	maxt := now - time.Hour.Milliseconds()
+
+	var buf bytes.Buffer
+	err = plugin.Export(context.Background(), ExportFormatParquet, mint, maxt, nil, "", &buf)
+	require.NoError(t, err)
+
+	rows, err := parquet.Read[exportRow](bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	require.Len(t, rows, 9*60+1)
+	require.Equal(t, mint, rows[0].Timestamp)
+	require.Equal(t, maxt, rows[len(rows)-1].Timestamp)
+}
@@ -0,0 +1,201 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+)
+
+// remoteBlock is a block in the file store.
+type remoteBlock struct {
+	// path is the directory of the block and root is the directory containing the block.
+	path string
+	root string
+	meta *tsdb.BlockMeta
+	// size is the total size of the block files, it's only set if needed.
+	size int64
+	// resolution is the resolution of the downsampled blocks in milliseconds, 0 for the raw blocks.
+	resolution int64
+}
+
+// blockFetcher fetches the blocks from the file store lazily as they are queried. It's meant
+// to be queried with consecutive time ranges, the blocks ending before the queried range are
+// removed from the local disk. Hence, only the blocks overlapping with the queried range are
+// kept on the local disk.
+type blockFetcher struct {
+	p   *Plugin
+	dir string
+
+	// pending blocks are sorted by their min time
+	pending []remoteBlock
+	open    []*tsdb.Block
+	// localDataDir is the directory of the blocks uploaded by the collecting node.
+	localDataDir string
+	// resolution is the coarsest resolution of the listed blocks, 0 if all blocks are raw.
+	resolution int64
+
+	progress *progressReporter
+}
+
+// newBlockFetcher lists the blocks overlapping with the job's range in the file store. If
+// requestLocal is set and this node is not collecting the metrics, the samples not yet synced
+// to the file store are requested from the collecting node. The fetcher should be closed to
+// remove the fetched blocks.
+func (p *Plugin) newBlockFetcher(ctx context.Context, job *DumpJob, dir, remoteStorageDir string, requestLocal bool) (*blockFetcher, error) {
+	f := &blockFetcher{
+		p:   p,
+		dir: dir,
+	}
+
+	blocks, resolution, err := p.listResolutionBlocks(remoteStorageDir, job.MinT, job.MaxT)
+	if err != nil {
+		return nil, err
+	}
+	f.pending = blocks
+	f.resolution = resolution
+
+	// the samples those are not synced to the file store yet are only available in the node
+	// collecting the metrics. If it's another node, we request the data from that node.
+	p.tsdbLock.RLock()
+	collecting := p.db != nil
+	p.tsdbLock.RUnlock()
+	if requestLocal && !collecting && p.isHA() {
+		localDataDir, err := p.requestLocalData(ctx, job)
+		if err != nil {
+			p.API.LogWarn("Could not fetch the local data of the collecting node", "err", err)
+		} else if localDataDir != "" {
+			f.localDataDir = localDataDir
+			blocks, err = p.listRemoteBlocks(localDataDir, job.MinT, job.MaxT)
+			if err != nil {
+				p.API.LogWarn("Could not list the local data of the collecting node", "err", err)
+			}
+			f.pending = append(f.pending, blocks...)
+		}
+	}
+
+	sort.Slice(f.pending, func(i, j int) bool {
+		return f.pending[i].meta.MinTime < f.pending[j].meta.MinTime
+	})
+
+	if err := os.MkdirAll(dir, 0740); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// listRemoteBlocks lists the blocks under root in the file store overlapping with [mint, maxt].
+func (p *Plugin) listRemoteBlocks(root string, mint, maxt int64) ([]remoteBlock, error) {
+	dirs, err := p.fileBackend.ListDirectory(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var blocks []remoteBlock
+	for _, b := range dirs {
+		meta, rErr := readBlockMeta(filepath.Join(b, metaFileName), p.fileBackend.ReadFile)
+		if rErr != nil {
+			// we intentionally log with debug level here, file store returns wrapped errors
+			// and to not pollute the logs, we simply reducing the log level here.
+			p.API.LogDebug("unable to read meta file", "err", rErr)
+			continue
+		}
+
+		// block time ranges are half-open: [MinTime, MaxTime)
+		if meta.MaxTime <= mint || meta.MinTime > maxt {
+			continue
+		}
+
+		blocks = append(blocks, remoteBlock{
+			path: b,
+			root: root,
+			meta: meta,
+		})
+	}
+
+	return blocks, nil
+}
+
+// Querier implements storage.Queryable. The queriers of the previous ranges should be closed
+// before calling it, since the blocks ending before mint are closed.
+func (f *blockFetcher) Querier(mint, maxt int64) (storage.Querier, error) {
+	open := f.open[:0]
+	for _, b := range f.open {
+		if b.Meta().MaxTime <= mint {
+			f.closeBlock(b)
+			continue
+		}
+		open = append(open, b)
+	}
+	f.open = open
+
+	for len(f.pending) > 0 && f.pending[0].meta.MinTime <= maxt {
+		rb := f.pending[0]
+		f.pending = f.pending[1:]
+
+		f.p.API.LogInfo("Fetching block from the filestore", "ulid", rb.meta.ULID)
+		if err := copyFromFileStore(f.dir, rb.path, rb.root, f.p.fileBackend); err != nil {
+			f.p.API.LogError("Error during fetching the block", "ulid", rb.meta.ULID, "err", err)
+			continue
+		}
+
+		b, err := tsdb.OpenBlock(f.p.logger, filepath.Join(f.dir, strings.TrimPrefix(rb.path, rb.root)), nil)
+		if err != nil {
+			f.p.API.LogError("Error during opening the block", "ulid", rb.meta.ULID, "err", err)
+			continue
+		}
+		f.open = append(f.open, b)
+		f.progress.blockFetched()
+	}
+
+	queriers := make([]storage.Querier, 0, len(f.open))
+	for _, b := range f.open {
+		if !b.OverlapsClosedInterval(mint, maxt) {
+			continue
+		}
+
+		q, err := tsdb.NewBlockQuerier(b, mint, maxt)
+		if err != nil {
+			for _, q := range queriers {
+				q.Close()
+			}
+			return nil, err
+		}
+		queriers = append(queriers, q)
+	}
+
+	// the blocks may overlap, the overlapping samples are deduplicated
+	return storage.NewMergeQuerier(queriers, nil, storage.ChainedSeriesMerge), nil
+}
+
+func (f *blockFetcher) closeBlock(b *tsdb.Block) {
+	if err := b.Close(); err != nil {
+		f.p.API.LogWarn("could not close the block", "ulid", b.Meta().ULID, "err", err)
+	}
+	os.RemoveAll(b.Dir())
+}
+
+// blocksTotal returns the number of blocks to be fetched.
+func (f *blockFetcher) blocksTotal() int {
+	return len(f.pending) + len(f.open)
+}
+
+// Close closes and removes the fetched blocks.
+func (f *blockFetcher) Close() {
+	for _, b := range f.open {
+		f.closeBlock(b)
+	}
+	f.open = nil
+
+	if f.localDataDir != "" {
+		f.p.removeLocalData(f.localDataDir)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/v8/platform/shared/filestore"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	pluginmocks "github.com/mattermost/mattermost-plugin-metrics/server/mocks/github.com/mattermost/mattermost/server/public/plugin"
+)
+
+func TestBlockFetcher(t *testing.T) {
+	now := time.Now().Truncate(time.Minute).UnixMilli()
+	lset := labels.FromStrings(labels.MetricName, "go_goroutines", "job", "prometheus")
+	db := createTestTSDB(t, now-12*time.Hour.Milliseconds(), now, lset)
+
+	// the remote blocks are written with the default block duration as the tsdb does
+	blocksDir := t.TempDir()
+	q, err := db.Querier(now-12*time.Hour.Milliseconds(), now)
+	require.NoError(t, err)
+	defer q.Close()
+
+	err = rewriteTSDB(context.Background(), log.NewNopLogger(), q, blocksDir, rewriteOptions{
+		mint:          now - 12*time.Hour.Milliseconds(),
+		maxt:          now,
+		blockDuration: tsdb.DefaultBlockDuration,
+	})
+	require.NoError(t, err)
+
+	fs, err := filestore.NewFileBackend(filestore.FileBackendSettings{
+		DriverName: "local",
+		Directory:  t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	remoteStorageDir := filepath.Join(pluginDataDir, PluginName, tsdbDirName)
+	require.NoError(t, copyDirectory(blocksDir, remoteStorageDir, fs.WriteFile))
+
+	api := &pluginmocks.MockAPI{}
+	api.On("GetConfig").Return(&model.Config{}).Maybe()
+	api.On("LogInfo", mock.Anything, mock.Anything, mock.Anything).Maybe()
+
+	plugin := &Plugin{
+		fileBackend: fs,
+		logger:      log.NewNopLogger(),
+	}
+	plugin.SetAPI(api)
+
+	mint := now - 9*time.Hour.Milliseconds()
+	maxt := now - time.Hour.Milliseconds()
+	job := &DumpJob{ID: model.NewId(), MinT: mint, MaxT: maxt}
+
+	fetchDir := filepath.Join(t.TempDir(), "fetch")
+	fetcher, err := plugin.newBlockFetcher(context.Background(), job, fetchDir, remoteStorageDir, true)
+	require.NoError(t, err)
+
+	dumpDir := filepath.Join(t.TempDir(), "data")
+	maxFetched := 0
+	err = plugin.rewriteDump(context.Background(), fetcher, dumpDir, nil, nil, mint, maxt, func(_ string, _ *tsdb.BlockMeta) error {
+		entries, rErr := os.ReadDir(fetchDir)
+		require.NoError(t, rErr)
+		maxFetched = max(maxFetched, len(entries))
+		return nil
+	})
+	require.NoError(t, err)
+
+	// only the blocks overlapping with a window are kept on the disk
+	require.LessOrEqual(t, maxFetched, 4)
+
+	series := readTestSeries(t, dumpDir)
+	require.Len(t, series[lset.String()], 8*60+1)
+
+	fetcher.Close()
+	entries, err := os.ReadDir(fetchDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
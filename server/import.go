@@ -0,0 +1,133 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/oklog/ulid"
+	"github.com/prometheus/prometheus/tsdb"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	importDirName = "import"
+)
+
+// importDir is the namespace of an imported dump in the file store. The imported blocks are
+// kept apart from the collected blocks so that they are never synced, cleaned up or dumped
+// along with the collected metrics.
+func importDir(id string) string {
+	return filepath.Join(pluginDataDir, PluginName, importDirName, id)
+}
+
+// CreateImportJob stores the uploaded dump archive in the file store and schedules a job
+// to import its blocks.
+func (p *Plugin) CreateImportJob(ctx context.Context, r io.Reader) (*DumpJob, error) {
+	id := model.NewId()
+	location := filepath.Join(importDir(id), zipFileName)
+
+	if _, err := p.fileBackend.WriteFile(r, location); err != nil {
+		if rErr := p.fileBackend.RemoveDirectory(importDir(id)); rErr != nil {
+			p.API.LogWarn("could not remove the incomplete archive", "err", rErr)
+		}
+		return nil, fmt.Errorf("could not store the archive: %w", err)
+	}
+
+	return p.CreateJob(ctx, &DumpJob{
+		ID:           id,
+		Type:         JobTypeImport,
+		DumpLocation: location,
+	})
+}
+
+// importDump extracts the archive of the job and copies its valid blocks into the
+// import namespace of the job. The import is aborted once the context is canceled.
+func (p *Plugin) importDump(ctx context.Context, job *DumpJob) (*Dump, error) {
+	cfg, err := p.getConfiguration()
+	if err != nil {
+		return nil, err
+	}
+
+	workDir := filepath.Join(importDirName, job.ID)
+	defer os.RemoveAll(workDir)
+
+	fr, err := p.fileBackend.Reader(job.DumpLocation)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the archive: %w", err)
+	}
+	defer fr.Close()
+
+	if err = extractArchive(ctx, fr, workDir, int64(*cfg.ImportMaxSizeMB)*1024*1024); err != nil {
+		return nil, fmt.Errorf("could not extract the archive: %w", err)
+	}
+
+	entries, err := os.ReadDir(workDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var mint, maxt int64
+	imported := 0
+	for _, entry := range entries {
+		if err = ctx.Err(); err != nil {
+			// the blocks imported so far are removed, the archive is kept for a retry
+			if rErr := p.fileBackend.RemoveDirectory(filepath.Join(importDir(job.ID), tsdbDirName)); rErr != nil {
+				p.API.LogWarn("could not remove the imported blocks", "err", rErr)
+			}
+			return nil, err
+		}
+
+		if !entry.IsDir() {
+			continue
+		}
+
+		if _, parseErr := ulid.Parse(entry.Name()); parseErr != nil {
+			// means that the directory is not a valid block
+			continue
+		}
+
+		// opening the block verifies that the index and the chunks are readable
+		blockDir := filepath.Join(workDir, entry.Name())
+		block, oErr := tsdb.OpenBlock(p.logger, blockDir, nil)
+		if oErr != nil {
+			p.API.LogWarn("Skipping invalid block in the archive", "ulid", entry.Name(), "err", oErr)
+			continue
+		}
+		meta := block.Meta()
+		block.Close()
+
+		err = p.uploadBlock(blockDir, filepath.Join(importDir(job.ID), tsdbDirName, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("could not copy block %s: %w", entry.Name(), err)
+		}
+
+		if meta.MinTime < mint || mint == 0 {
+			mint = meta.MinTime
+		}
+		// block time ranges are half-open: [MinTime, MaxTime)
+		if meta.MaxTime-1 > maxt {
+			maxt = meta.MaxTime - 1
+		}
+		imported++
+	}
+
+	if imported == 0 {
+		return nil, errors.New("no valid blocks in the archive")
+	}
+
+	p.API.LogInfo("Dump imported", "id", job.ID, "blocks", imported)
+
+	return &Dump{
+		Path: job.DumpLocation,
+		MinT: mint,
+		MaxT: maxt,
+	}, nil
+}
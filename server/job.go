@@ -5,7 +5,7 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"path/filepath"
 	"strings"
@@ -18,10 +18,16 @@ import (
 )
 
 const (
-	JobLockKey    = PluginName + "_job_lock"
+	JobLockKey = PluginName + "_job_lock"
+	// KVStoreJobKey is where the previous versions stored the finished jobs, see migrateJobStore.
 	KVStoreJobKey = PluginName + "_finished_jobs"
+
+	JobTypeDump   = "dump"
+	JobTypeImport = "import"
 )
 
+var errJobNotRetriable = errors.New("only the failed or canceled jobs can be retried")
+
 type DumpJob struct {
 	ID           string `json:"id"`
 	Status       string `json:"status"`
@@ -29,10 +35,45 @@ type DumpJob struct {
 	MinT         int64  `json:"min_t"`
 	MaxT         int64  `json:"max_t"`
 	DumpLocation string `json:"dump_location"`
+	// ScheduleID is set if the job is created by a DumpSchedule.
+	ScheduleID string `json:"schedule_id,omitempty"`
+	// Matchers are the series selectors, e.g. `{job="calls"}`. If set, only
+	// the matching series are written into the dump.
+	Matchers []string `json:"matchers,omitempty"`
+	// Format is the format of the dump contents, either DumpFormatTSDB (default) or
+	// DumpFormatOpenMetrics.
+	Format string `json:"format,omitempty"`
+	// Type is either JobTypeDump (default) or JobTypeImport.
+	Type string `json:"type,omitempty"`
+	// Checksum is the hex encoded SHA-256 checksum of the dump archive.
+	Checksum string `json:"checksum,omitempty"`
+	// Encrypted is set if the dump archive is encrypted with age.
+	Encrypted bool `json:"encrypted,omitempty"`
+	// Anonymization is either AnonymizationHash or AnonymizationRedact if the values of the
+	// configured labels are anonymized in the dump.
+	Anonymization string `json:"anonymization,omitempty"`
+	// CreatorID is the user created the job, it's empty for the scheduled jobs.
+	CreatorID string `json:"creator_id,omitempty"`
+	// Delivery is the optional target the dump is delivered to once it's created.
+	Delivery *DumpDelivery `json:"delivery,omitempty"`
+	// Size is the size of the dump archive in bytes.
+	Size int64 `json:"size,omitempty"`
+	// Resolution is the coarsest resolution of the downsampled samples in the dump in
+	// milliseconds, 0 if the dump has the raw samples only.
+	Resolution int64 `json:"resolution,omitempty"`
+	// Progress is set while the job is in progress.
+	Progress *JobProgress `json:"progress,omitempty"`
+	// Error is the reason of the failure if the job is failed.
+	Error string `json:"error,omitempty"`
+	// StartAt is the time the job is started to run.
+	StartAt int64 `json:"start_at,omitempty"`
+	// FailedPhase is the phase of the job when it failed, it's empty if the job failed
+	// before reporting any progress.
+	FailedPhase string `json:"failed_phase,omitempty"`
 }
 
-// here it is required to acquire an exclusive lock to avoid
-// race in an HA environment if there are parallel job processing requests
+// here it is required to acquire an exclusive lock to avoid race in an HA
+// environment if the nodes are migrating the jobs at the same time
 func (p *Plugin) lockJobKVMutex(ctx context.Context) (func(), error) {
 	lock, err := cluster.NewMutex(p.API, root.Manifest.Id+JobLockKey)
 	if err != nil {
@@ -53,167 +94,260 @@ func (p *Plugin) JobCallback(_ string, job any) {
 		return
 	}
 
+	ctx := p.startJob(dumpJob.ID)
+	defer p.finishJob(dumpJob.ID)
+
+	timeout := p.jobTimeout()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
 	dumpJob.Status = model.JobStatusInProgress
-	err := p.UpdateJob(context.TODO(), dumpJob)
+	dumpJob.StartAt = time.Now().UnixMilli()
+	err := p.UpdateJob(ctx, dumpJob)
 	if err != nil {
 		p.API.LogError("could not update job status", "err", err)
 		return
 	}
 
+	isOwnRun := ownedByRun(dumpJob, dumpJob.StartAt)
+
 	defer func() {
-		err = p.UpdateJob(context.TODO(), dumpJob)
-		if err != nil {
-			p.API.LogError("could not update job status", "err", err)
+		// the job is already removed from the store if it's deleted while in progress
+		if errors.Is(context.Cause(ctx), errJobDeleted) {
+			p.API.LogInfo("Job deleted while in progress", "id", dumpJob.ID)
+			return
+		}
+
+		dumpJob.Progress = nil
+		stored, sErr := p.saveJobIf(dumpJob, isOwnRun)
+		if sErr != nil {
+			p.API.LogError("could not update job status", "err", sErr)
+			return
+		} else if !stored {
+			p.API.LogWarn("Job is modified while in progress, its result is discarded", "id", dumpJob.ID, "status", dumpJob.Status)
 			return
 		}
+
+		p.notifyJobFinished(dumpJob)
 	}()
 
-	remoteStorageDir := filepath.Join(pluginDataDir, PluginName, tsdbDirName)
-	dump, err := p.createDump(context.TODO(), dumpJob.ID, time.UnixMilli(dumpJob.MinT), time.UnixMilli(dumpJob.MaxT), remoteStorageDir)
-	if err != nil {
+	progress := newProgressReporter(func(progress JobProgress) {
+		dumpJob.Progress = &progress
+		if _, uErr := p.saveJobIf(dumpJob, isOwnRun); uErr != nil {
+			p.API.LogWarn("could not update job progress", "err", uErr)
+		}
+	})
+
+	// the heartbeat tells the other nodes that the job is still running
+	stopHeartbeat := progress.keepAlive(jobHeartbeatInterval)
+
+	var dump *Dump
+	if dumpJob.Type == JobTypeImport {
+		dump, err = p.importDump(ctx, dumpJob)
+	} else {
+		remoteStorageDir := filepath.Join(pluginDataDir, PluginName, tsdbDirName)
+		dump, err = p.createDump(ctx, dumpJob, remoteStorageDir, progress)
+	}
+	stopHeartbeat()
+	if err != nil && errors.Is(context.Cause(ctx), context.DeadlineExceeded) {
 		dumpJob.Status = model.JobStatusError
-		p.API.LogError("could not create dump", "err", err)
+		dumpJob.Error = fmt.Sprintf("the job did not finish in %s", timeout)
+		dumpJob.FailedPhase = progress.phase()
+		p.API.LogError("Job timed out", "id", dumpJob.ID, "phase", dumpJob.FailedPhase)
+		return
+	} else if err != nil && ctx.Err() != nil {
+		dumpJob.Status = model.JobStatusCanceled
+		p.API.LogInfo("Job canceled", "id", dumpJob.ID)
+		return
+	} else if err != nil {
+		dumpJob.Status = model.JobStatusError
+		dumpJob.Error = err.Error()
+		dumpJob.FailedPhase = progress.phase()
+		p.API.LogError("could not create dump", "type", dumpJob.Type, "phase", dumpJob.FailedPhase, "err", err)
 		return
 	}
 
 	dumpJob.DumpLocation = dump.Path
 	dumpJob.MinT = dump.MinT
 	dumpJob.MaxT = dump.MaxT
+	dumpJob.Checksum = dump.Checksum
+	dumpJob.Encrypted = dump.Encrypted
+	dumpJob.Size = dump.Size
+	dumpJob.Resolution = dump.Resolution
 	dumpJob.Status = model.JobStatusSuccess
+
+	if dumpJob.Delivery != nil {
+		// the job is stored as successful before the delivery, which may take a while, so
+		// that the dump is kept if the node stops while delivering it
+		dumpJob.Progress = nil
+		stored, sErr := p.saveJobIf(dumpJob, isOwnRun)
+		if sErr != nil {
+			p.API.LogError("could not update job status", "err", sErr)
+		} else if !stored {
+			// the dump is not delivered if the job is not owned by this run anymore
+			return
+		}
+
+		// the dump is kept in the file store even if the delivery fails
+		if err = p.deliverDump(ctx, dumpJob); err != nil {
+			dumpJob.Delivery.Error = err.Error()
+			p.API.LogError("could not deliver the dump", "id", dumpJob.ID, "type", dumpJob.Delivery.Type, "err", err)
+			return
+		}
+		dumpJob.Delivery.DeliveredAt = time.Now().UnixMilli()
+	}
 }
 
-func (p *Plugin) CreateJob(_ context.Context, min, max int64) (*DumpJob, error) {
-	jobID := model.NewId()
-	job := &DumpJob{
-		ID:       jobID,
-		Status:   model.JobStatusPending,
-		CreateAt: time.Now().UnixMilli(),
-		MinT:     min,
-		MaxT:     max,
+// ownedByRun returns the condition of storing the job by its run started at startAt. The job is
+// stored only while the run owns it, i.e. the stored job is not marked as failed by the stale
+// jobs check, retried or removed in the meantime.
+func ownedByRun(job *DumpJob, startAt int64) func(prev *DumpJob) bool {
+	return func(prev *DumpJob) bool {
+		return prev != nil && prev.StartAt == startAt && (prev.Status == model.JobStatusInProgress || prev.Status == job.Status)
 	}
+}
 
-	_, err := p.scheduler.ScheduleOnce(job.ID, time.Now(), job)
-	if err != nil {
-		return nil, err
+// VerifyDump reads the dump archive of the job from the file store and verifies it against
+// the manifest in the archive and the checksum recorded when the dump was created.
+func (p *Plugin) VerifyDump(_ context.Context, job *DumpJob) (*ManifestVerification, error) {
+	if job.Status != model.JobStatusSuccess || job.Type == JobTypeImport {
+		return nil, errors.New("the job does not have a dump")
 	}
 
-	return job, nil
-}
+	fr, err := p.fileBackend.Reader(job.DumpLocation)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the dump: %w", err)
+	}
+	defer fr.Close()
 
-func (p *Plugin) GetAllJobs(ctx context.Context) (map[string]*DumpJob, error) {
-	metas, err := p.scheduler.ListScheduledJobs()
+	var result *ManifestVerification
+	if job.Encrypted {
+		result, err = p.verifyEncryptedArchive(fr)
+	} else {
+		result, err = verifyArchive(fr)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	// get active jobs
-	jobs := make(map[string]*DumpJob)
-	for _, meta := range metas {
-		prop, ok := meta.Props.(map[string]any)
-		if !ok {
-			p.API.LogWarn("could not cast props into string map", "props", prop)
-			continue
-		}
-
-		b, err2 := json.Marshal(prop)
-		if err2 != nil {
-			p.API.LogWarn("could not marshal props", "id", meta.Key, "err", err2)
-			continue
-		}
+	if result.Valid && job.Checksum != "" && result.Checksum != job.Checksum {
+		result.Valid = false
+		result.Error = "the archive checksum does not match the checksum of the created dump"
+	}
 
-		var j DumpJob
-		if err2 := json.Unmarshal(b, &j); err2 != nil {
-			// this is an expected case if the job props are not a DumpJob compatible type
-			// we continue to parse other jobs to get actual dump jobs.
-			continue
-		}
+	return result, nil
+}
 
-		jobs[meta.Key] = &j
+// CreateJob schedules a dump job with the requested properties of the given job,
+// the status and creation time are set by this method. The ID is generated unless
+// it is already set.
+func (p *Plugin) CreateJob(_ context.Context, job *DumpJob) (*DumpJob, error) {
+	if job.ID == "" {
+		job.ID = model.NewId()
 	}
+	job.Status = model.JobStatusPending
+	job.CreateAt = time.Now().UnixMilli()
 
-	// we also get the finishedjobs
-	unlock, err := p.lockJobKVMutex(ctx)
-	if err != nil {
+	// the job is stored before it's scheduled, otherwise it may start before it's stored
+	if err := p.saveJob(job, false); err != nil {
 		return nil, err
 	}
-	defer unlock()
-
-	b, appErr := p.API.KVGet(KVStoreJobKey)
-	if appErr != nil {
-		return nil, fmt.Errorf("could not retrieve jobs: %w", appErr)
-	}
-
-	if len(b) > 0 {
-		var finishedJobs map[string]*DumpJob
-		err = json.Unmarshal(b, &finishedJobs)
-		if err != nil {
-			return nil, fmt.Errorf("could not unmarshal jobs: %w", err)
-		}
 
-		for id, job := range finishedJobs {
-			jobs[id] = job
+	_, err := p.scheduler.ScheduleOnce(job.ID, time.Now(), job)
+	if err != nil {
+		if rErr := p.removeJob(job.ID); rErr != nil {
+			p.API.LogWarn("could not remove the unscheduled job", "id", job.ID, "err", rErr)
 		}
+		return nil, err
 	}
 
-	return jobs, nil
+	return job, nil
 }
 
-func (p *Plugin) DeleteJob(ctx context.Context, id string) error {
-	unlock, err := p.lockJobKVMutex(ctx)
+// RetryJob re-schedules a failed or canceled job with the same ID and the same requested
+// properties, e.g. the range, matchers and the format.
+func (p *Plugin) RetryJob(ctx context.Context, id string) (*DumpJob, error) {
+	job, err := p.GetJob(ctx, id)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer unlock()
 
-	b, appErr := p.API.KVGet(KVStoreJobKey)
-	if appErr != nil {
-		return fmt.Errorf("could not retrieve jobs: %w", appErr)
+	if job.Status != model.JobStatusError && job.Status != model.JobStatusCanceled {
+		return nil, fmt.Errorf("%w: %s", errJobNotRetriable, job.Status)
 	}
 
-	var jobs map[string]*DumpJob
-	err = json.Unmarshal(b, &jobs)
-	if err != nil {
-		return fmt.Errorf("could not unmarshal jobs: %w", err)
+	job.resetResults()
+
+	return p.CreateJob(ctx, job)
+}
+
+// resetResults clears the fields set while the job runs, so that only the requested
+// properties are kept for a retry.
+func (job *DumpJob) resetResults() {
+	job.Error = ""
+	job.FailedPhase = ""
+	job.StartAt = 0
+	job.Progress = nil
+	job.Checksum = ""
+	job.Encrypted = false
+	job.Size = 0
+	job.Resolution = 0
+	if job.Delivery != nil {
+		job.Delivery.DeliveredAt = 0
+		job.Delivery.Error = ""
+	}
+	if job.Type == JobTypeImport {
+		// the imported archive is kept in the dump location, the range is read from its blocks
+		job.MinT = 0
+		job.MaxT = 0
+	} else {
+		job.DumpLocation = ""
 	}
+}
 
-	if _, ok := jobs[id]; !ok {
-		// job doesn't exists in finished jobs
-		// it's most likely a shceduledjob we hand it over to
-		// scheduler to delete it
+func (p *Plugin) DeleteJob(ctx context.Context, id string) error {
+	job, err := p.GetJob(ctx, id)
+	if errors.Is(err, errJobNotFound) {
+		// the job might be scheduled by a previous version without being stored,
+		// we hand it over to the scheduler to delete it
 		p.API.LogDebug("canceling scheduled job", "id", id)
 		p.scheduler.Cancel(id)
 		return nil
+	} else if err != nil {
+		return err
 	}
 
-	ok, err := jobs[id].DeleteDump(p)
-	if err != nil {
-		p.API.LogError("dump could not be deleted", "id", jobs[id].ID, "err", err.Error())
-	} else if ok {
-		p.API.LogDebug("dump deleted", "id", jobs[id].ID, "file", jobs[id].DumpLocation)
+	switch job.Status {
+	case model.JobStatusPending:
+		p.scheduler.Cancel(id)
+	case model.JobStatusInProgress:
+		// the running job removes its partial dump once it's canceled
+		if err = p.cancelRunningJob(id, true); err != nil {
+			p.API.LogError("could not cancel the running job", "id", id, "err", err)
+		}
 	}
 
-	delete(jobs, id)
-
-	b, err = json.Marshal(jobs)
+	ok, err := job.DeleteDump(p)
 	if err != nil {
-		return fmt.Errorf("could not marshal jobs: %w", err)
+		p.API.LogError("dump could not be deleted", "id", job.ID, "err", err.Error())
+	} else if ok {
+		p.API.LogDebug("dump deleted", "id", job.ID, "file", job.DumpLocation)
 	}
 
-	appErr = p.API.KVSet(KVStoreJobKey, b)
-	if appErr != nil {
-		return fmt.Errorf("could not store jobs: %w", appErr)
-	}
-	return nil
+	return p.removeJob(id)
 }
 
 func (p *Plugin) DeleteAllJobs(ctx context.Context) error {
-	jobs, err := p.GetAllJobs(ctx)
+	jobs, err := p.listAllJobs(ctx, JobFilter{})
 	if err != nil {
 		return err
 	}
 
-	for id := range jobs {
-		err = p.DeleteJob(ctx, id)
+	for _, job := range jobs {
+		err = p.DeleteJob(ctx, job.ID)
 		if err != nil {
 			return err
 		}
@@ -227,44 +361,19 @@ func (p *Plugin) DeleteAllJobs(ctx context.Context) error {
 	}
 	p.API.LogInfo("Dump directory removed from the file store.")
 
-	return nil
-}
-
-func (p *Plugin) UpdateJob(ctx context.Context, job *DumpJob) error {
-	unlock, err := p.lockJobKVMutex(ctx)
+	err = p.fileBackend.RemoveDirectory(filepath.Join(pluginDataDir, PluginName, importDirName))
 	if err != nil {
 		return err
 	}
-	defer unlock()
-
-	b, appErr := p.API.KVGet(KVStoreJobKey)
-	if appErr != nil {
-		return fmt.Errorf("could not retrieve jobs: %w", appErr)
-	}
-
-	jobs := make(map[string]*DumpJob)
-
-	if len(b) > 0 {
-		err = json.Unmarshal(b, &jobs)
-		if err != nil {
-			return fmt.Errorf("could not unmarshal jobs: %w", err)
-		}
-	}
-
-	jobs[job.ID] = job
-	b, err = json.Marshal(jobs)
-	if err != nil {
-		return fmt.Errorf("could not marshal jobs: %w", err)
-	}
-
-	appErr = p.API.KVSet(KVStoreJobKey, b)
-	if appErr != nil {
-		return fmt.Errorf("could not store jobs: %w", appErr)
-	}
+	p.API.LogInfo("Import directory removed from the file store.")
 
 	return nil
 }
 
+func (p *Plugin) UpdateJob(_ context.Context, job *DumpJob) error {
+	return p.saveJob(job, false)
+}
+
 func (j *DumpJob) DeleteDump(p *Plugin) (bool, error) {
 	// do not delete if the dump location is not under the plugin-data directory.
 	//
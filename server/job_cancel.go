@@ -0,0 +1,137 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	clusterEventCancelJob = "cancel_job"
+)
+
+var (
+	errJobCanceled   = errors.New("the job is canceled")
+	errJobDeleted    = errors.New("the job is deleted")
+	errJobNotFound   = errors.New("the job is not found")
+	errJobNotRunning = errors.New("the job is not running")
+)
+
+// cancelJobRequest is sent to the other nodes since the job might be running on any node.
+type cancelJobRequest struct {
+	JobID   string `json:"job_id"`
+	Deleted bool   `json:"deleted"`
+}
+
+// startJob registers the running job so that it can be canceled. The returned context is
+// canceled once the job is canceled, finishJob should be called when the job is done.
+func (p *Plugin) startJob(id string) context.Context {
+	ctx, cancel := context.WithCancelCause(context.Background())
+
+	p.runningJobsLock.Lock()
+	defer p.runningJobsLock.Unlock()
+
+	if p.runningJobs == nil {
+		p.runningJobs = make(map[string]context.CancelCauseFunc)
+	}
+	p.runningJobs[id] = cancel
+
+	return ctx
+}
+
+func (p *Plugin) finishJob(id string) {
+	p.runningJobsLock.Lock()
+	defer p.runningJobsLock.Unlock()
+
+	if cancel, ok := p.runningJobs[id]; ok {
+		cancel(nil)
+		delete(p.runningJobs, id)
+	}
+}
+
+// cancelLocalJob cancels the job if it's running on this node.
+func (p *Plugin) cancelLocalJob(id string, cause error) bool {
+	p.runningJobsLock.Lock()
+	defer p.runningJobsLock.Unlock()
+
+	cancel, ok := p.runningJobs[id]
+	if ok {
+		cancel(cause)
+	}
+
+	return ok
+}
+
+// cancelRunningJob cancels the in progress job on whichever node it's running.
+func (p *Plugin) cancelRunningJob(id string, deleted bool) error {
+	cause := errJobCanceled
+	if deleted {
+		cause = errJobDeleted
+	}
+
+	if p.cancelLocalJob(id, cause) || !p.isHA() {
+		return nil
+	}
+
+	b, err := json.Marshal(cancelJobRequest{
+		JobID:   id,
+		Deleted: deleted,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = p.API.PublishPluginClusterEvent(model.PluginClusterEvent{
+		Id:   clusterEventCancelJob,
+		Data: b,
+	}, model.PluginClusterEventSendOptions{
+		SendType: model.PluginClusterEventSendTypeReliable,
+	})
+	if err != nil {
+		return fmt.Errorf("could not publish cancel request: %w", err)
+	}
+
+	return nil
+}
+
+func (p *Plugin) handleCancelJobEvent(data []byte) {
+	var req cancelJobRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		p.API.LogError("could not unmarshal cancel job request", "err", err)
+		return
+	}
+
+	cause := errJobCanceled
+	if req.Deleted {
+		cause = errJobDeleted
+	}
+
+	if p.cancelLocalJob(req.JobID, cause) {
+		p.API.LogInfo("Job canceled", "id", req.JobID)
+	}
+}
+
+// CancelJob cancels a pending or an in progress job.
+func (p *Plugin) CancelJob(ctx context.Context, id string) error {
+	job, err := p.GetJob(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	switch job.Status {
+	case model.JobStatusPending:
+		p.scheduler.Cancel(id)
+		job.Status = model.JobStatusCanceled
+		return p.saveJob(job, true)
+	case model.JobStatusInProgress:
+		return p.cancelRunningJob(id, false)
+	}
+
+	return fmt.Errorf("%w: %s", errJobNotRunning, job.Status)
+}
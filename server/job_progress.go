@@ -0,0 +1,150 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	JobPhaseFetch    = "fetch"
+	JobPhaseCompact  = "compact"
+	JobPhaseCompress = "compress"
+	JobPhaseUpload   = "upload"
+
+	// jobProgressInterval is the minimum interval between the progress updates stored in the
+	// KV store, the phase changes are always stored.
+	jobProgressInterval = 5 * time.Second
+	// jobHeartbeatInterval is the interval of storing the progress of a job even if it did
+	// not change, so that the jobs left in progress by a stopped node can be detected.
+	jobHeartbeatInterval = time.Minute
+)
+
+// JobProgress is the progress of an in progress DumpJob.
+type JobProgress struct {
+	Phase         string `json:"phase"`
+	BlocksFetched int    `json:"blocks_fetched"`
+	BlocksTotal   int    `json:"blocks_total"`
+	BytesWritten  int64  `json:"bytes_written"`
+	UpdateAt      int64  `json:"update_at"`
+}
+
+// progressReporter tracks the progress of a job and reports it periodically. A nil
+// progressReporter is valid and ignores the updates.
+type progressReporter struct {
+	mut        sync.Mutex
+	progress   JobProgress
+	lastReport time.Time
+	// reportMut serializes the reports of the heartbeat and of the progress updates.
+	reportMut sync.Mutex
+	report    func(JobProgress)
+}
+
+func newProgressReporter(report func(JobProgress)) *progressReporter {
+	return &progressReporter{
+		report: report,
+	}
+}
+
+func (r *progressReporter) setPhase(phase string) {
+	r.update(func(p *JobProgress) {
+		p.Phase = phase
+	}, true)
+}
+
+// phase returns the current phase of the job.
+func (r *progressReporter) phase() string {
+	if r == nil {
+		return ""
+	}
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	return r.progress.Phase
+}
+
+func (r *progressReporter) setBlocksTotal(total int) {
+	r.update(func(p *JobProgress) {
+		p.BlocksTotal = total
+	}, false)
+}
+
+func (r *progressReporter) blockFetched() {
+	r.update(func(p *JobProgress) {
+		p.BlocksFetched++
+	}, false)
+}
+
+func (r *progressReporter) addBytes(n int64) {
+	r.update(func(p *JobProgress) {
+		p.BytesWritten += n
+	}, false)
+}
+
+func (r *progressReporter) update(fn func(*JobProgress), force bool) {
+	if r == nil {
+		return
+	}
+
+	r.mut.Lock()
+	fn(&r.progress)
+	now := time.Now()
+	if !force && now.Sub(r.lastReport) < jobProgressInterval {
+		r.mut.Unlock()
+		return
+	}
+	r.lastReport = now
+	r.progress.UpdateAt = now.UnixMilli()
+	progress := r.progress
+	r.mut.Unlock()
+
+	r.reportMut.Lock()
+	defer r.reportMut.Unlock()
+	r.report(progress)
+}
+
+// keepAlive reports the progress right away and then every interval until the returned
+// function is called. The returned function waits for the in flight report to finish.
+func (r *progressReporter) keepAlive(interval time.Duration) func() {
+	if r == nil {
+		return func() {}
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			r.update(func(*JobProgress) {}, true)
+
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		wg.Wait()
+	}
+}
+
+// progressWriter counts the bytes written through it.
+type progressWriter struct {
+	progress *progressReporter
+}
+
+func (w progressWriter) Write(b []byte) (int, error) {
+	w.progress.addBytes(int64(len(b)))
+	return len(b), nil
+}
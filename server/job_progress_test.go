@@ -0,0 +1,84 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgressReporter(t *testing.T) {
+	var reports []JobProgress
+	progress := newProgressReporter(func(p JobProgress) {
+		reports = append(reports, p)
+	})
+
+	progress.setPhase(JobPhaseFetch)
+	progress.setBlocksTotal(3)
+	progress.blockFetched()
+	progress.addBytes(512)
+
+	// the updates are throttled but the phase changes are always reported
+	require.Len(t, reports, 1)
+	progress.setPhase(JobPhaseUpload)
+	require.Len(t, reports, 2)
+
+	last := reports[1]
+	require.Equal(t, JobPhaseUpload, last.Phase)
+	require.Equal(t, 3, last.BlocksTotal)
+	require.Equal(t, 1, last.BlocksFetched)
+	require.Equal(t, int64(512), last.BytesWritten)
+	require.NotZero(t, last.UpdateAt)
+
+	t.Run("heartbeat", func(t *testing.T) {
+		reported := make(chan JobProgress, 10)
+		progress := newProgressReporter(func(p JobProgress) {
+			reported <- p
+		})
+		progress.setPhase(JobPhaseFetch)
+		<-reported
+
+		stop := progress.keepAlive(10 * time.Millisecond)
+		for i := 0; i < 2; i++ {
+			// the unchanged progress is reported to refresh its update time
+			p := <-reported
+			require.Equal(t, JobPhaseFetch, p.Phase)
+			require.NotZero(t, p.UpdateAt)
+		}
+		stop()
+
+		// no reports after the heartbeat is stopped
+		for len(reported) > 0 {
+			<-reported
+		}
+		time.Sleep(30 * time.Millisecond)
+		require.Empty(t, reported)
+	})
+
+	t.Run("nil reporter", func(t *testing.T) {
+		var progress *progressReporter
+		require.NotPanics(t, func() {
+			progress.setPhase(JobPhaseCompact)
+			progress.addBytes(1)
+			progress.keepAlive(time.Millisecond)()
+		})
+	})
+}
+
+func TestCancelLocalJob(t *testing.T) {
+	plugin := &Plugin{}
+
+	ctx := plugin.startJob("job1")
+	require.False(t, plugin.cancelLocalJob("job2", errJobCanceled))
+	require.NoError(t, ctx.Err())
+
+	require.True(t, plugin.cancelLocalJob("job1", errJobDeleted))
+	require.ErrorIs(t, context.Cause(ctx), errJobDeleted)
+
+	plugin.finishJob("job1")
+	require.False(t, plugin.cancelLocalJob("job1", errJobCanceled))
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	staleJobsKey = PluginName + "_stale_jobs"
+
+	// staleJobsInterval is the period of checking the stale jobs.
+	staleJobsInterval = 5 * time.Minute
+	// staleJobGracePeriod is added to the job timeout before considering a job without a
+	// heartbeat stale, the node running the job should have marked it as failed in the meantime.
+	staleJobGracePeriod = 10 * time.Minute
+	// staleJobHeartbeatTimeout is the duration after which a job is considered stale if its
+	// progress is not stored, i.e. several heartbeats are missed.
+	staleJobHeartbeatTimeout = 5 * jobHeartbeatInterval
+)
+
+// jobTimeout returns the maximum duration of a job, 0 if there is no limit.
+func (p *Plugin) jobTimeout() time.Duration {
+	cfg, err := p.getConfiguration()
+	if err != nil {
+		p.API.LogWarn("could not get plugin configuration", "err", err)
+		return 0
+	}
+
+	return time.Duration(*cfg.DumpJobTimeoutMinutes) * time.Minute
+}
+
+// isStale tells whether the in progress job is not running anymore, e.g. the node running
+// the job is stopped while the job is in progress. The jobs reporting a heartbeat are stale
+// once the heartbeat stops, the jobs started before the heartbeat was introduced are stale
+// once they exceed the timeout.
+func (j *DumpJob) isStale(now time.Time, timeout time.Duration) bool {
+	if j.Status != model.JobStatusInProgress {
+		return false
+	}
+
+	if j.Progress != nil && j.Progress.UpdateAt != 0 {
+		return now.Sub(time.UnixMilli(j.Progress.UpdateAt)) > staleJobHeartbeatTimeout
+	}
+
+	if timeout == 0 {
+		return false
+	}
+
+	startAt := j.StartAt
+	if startAt == 0 {
+		// the jobs started before the start time is recorded
+		startAt = j.CreateAt
+	}
+
+	return now.Sub(time.UnixMilli(startAt)) > timeout+staleJobGracePeriod
+}
+
+// failStaleJobs is called periodically by a cluster job, it marks the stale jobs as
+// failed so that those can be retried.
+func (p *Plugin) failStaleJobs() {
+	timeout := p.jobTimeout()
+	ctx := context.TODO()
+	jobs, err := p.listAllJobs(ctx, JobFilter{
+		Statuses: []string{model.JobStatusInProgress},
+	})
+	if err != nil {
+		p.API.LogError("could not get jobs", "err", err)
+		return
+	}
+
+	now := time.Now()
+	for _, job := range jobs {
+		if job.isStale(now, timeout) {
+			p.failStaleJob(job, timeout)
+		}
+	}
+}
+
+// failStaleJob marks the stale job as failed. The job is marked as failed only if it's still
+// in progress with the heartbeat read, e.g. it's not finished or it didn't report its progress
+// in the meantime.
+func (p *Plugin) failStaleJob(job *DumpJob, timeout time.Duration) {
+	var heartbeat int64
+	if job.Progress != nil {
+		heartbeat = job.Progress.UpdateAt
+	}
+	isUnchanged := func(prev *DumpJob) bool {
+		if prev == nil || prev.Status != model.JobStatusInProgress || prev.StartAt != job.StartAt {
+			return false
+		}
+		if prev.Progress == nil {
+			return heartbeat == 0
+		}
+		return prev.Progress.UpdateAt == heartbeat
+	}
+
+	job.Status = model.JobStatusError
+	job.Error = fmt.Sprintf("the job did not finish in %s, the node running it might have been stopped", timeout)
+	if job.Progress != nil {
+		job.FailedPhase = job.Progress.Phase
+		if job.Progress.UpdateAt != 0 {
+			job.Error = fmt.Sprintf("the job did not report its progress since %s, the node running it might have been stopped", time.UnixMilli(job.Progress.UpdateAt).UTC().Format(time.RFC3339))
+		}
+	}
+	job.Progress = nil
+
+	stored, err := p.saveJobIf(job, isUnchanged)
+	if err != nil {
+		p.API.LogError("could not update the stale job", "id", job.ID, "err", err)
+		return
+	} else if !stored {
+		return
+	}
+
+	// the scheduler re-runs the jobs interrupted before completion, we don't want
+	// the job to be run after it's marked as failed.
+	p.scheduler.Cancel(job.ID)
+
+	p.API.LogWarn("Stale job marked as failed", "id", job.ID, "start_at", job.StartAt)
+	p.notifyJobFinished(job)
+
+	if job.Type == JobTypeImport {
+		// the imported archive is kept so that the job can be retried
+		return
+	}
+
+	// remove the partially uploaded dump, if any
+	if err = p.fileBackend.RemoveDirectory(filepath.Join(pluginDataDir, PluginName, "dump", job.ID)); err != nil {
+		p.API.LogWarn("could not remove the partial dump of the stale job", "id", job.ID, "err", err)
+	}
+}
@@ -0,0 +1,528 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	// KVStoreJobPrefix is the prefix of the keys of the jobs, each job is stored in its own key.
+	KVStoreJobPrefix = PluginName + "_job_"
+	// KVStoreJobIndexKey is the key of the index of the jobs.
+	KVStoreJobIndexKey = PluginName + "_jobs_index"
+
+	jobIndexReconcileKey = PluginName + "_jobs_index_reconcile"
+	// jobIndexReconcileInterval is the period of repairing the index entries left stale by
+	// the concurrent or failed index updates.
+	jobIndexReconcileInterval = 15 * time.Minute
+	// kvListPerPage is the number of keys read at once while listing the stored jobs.
+	kvListPerPage = 1000
+
+	defaultJobsPerPage = 50
+	maxJobsPerPage     = 200
+
+	// maxCompareAndSetAttempts is the number of attempts to store a value that is
+	// concurrently modified by other requests or nodes.
+	maxCompareAndSetAttempts = 10
+)
+
+var errConcurrentModification = errors.New("the value is modified concurrently")
+
+// jobIndexEntry is the summary of a job kept in the index, so that the jobs can be filtered
+// and sorted without reading each of them.
+type jobIndexEntry struct {
+	ID       string `json:"id"`
+	CreateAt int64  `json:"create_at"`
+	Status   string `json:"status"`
+}
+
+// JobFilter filters and paginates the listed jobs, the zero value lists all jobs.
+type JobFilter struct {
+	Statuses []string
+	// CreatedAfter and CreatedBefore limit the creation time of the jobs inclusively,
+	// zero means no limit.
+	CreatedAfter  int64
+	CreatedBefore int64
+	// Page is zero based. If PerPage is zero, all matching jobs are returned.
+	Page    int
+	PerPage int
+}
+
+func isValidJobStatus(status string) bool {
+	switch status {
+	case model.JobStatusPending, model.JobStatusInProgress, model.JobStatusSuccess, model.JobStatusError, model.JobStatusCanceled:
+		return true
+	}
+	return false
+}
+
+func (f JobFilter) matches(e jobIndexEntry) bool {
+	if len(f.Statuses) > 0 {
+		found := false
+		for _, status := range f.Statuses {
+			found = found || status == e.Status
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if f.CreatedAfter != 0 && e.CreateAt < f.CreatedAfter {
+		return false
+	}
+	if f.CreatedBefore != 0 && e.CreateAt > f.CreatedBefore {
+		return false
+	}
+
+	return true
+}
+
+// JobList is a page of the jobs sorted by the creation time, newest first.
+type JobList struct {
+	Jobs []*DumpJob `json:"jobs"`
+	// Total is the number of the jobs matching the filter.
+	Total int `json:"total"`
+}
+
+func jobKey(id string) string {
+	return KVStoreJobPrefix + id
+}
+
+// GetJob returns the job, errJobNotFound is returned if the job doesn't exist.
+func (p *Plugin) GetJob(_ context.Context, id string) (*DumpJob, error) {
+	b, appErr := p.API.KVGet(jobKey(id))
+	if appErr != nil {
+		return nil, fmt.Errorf("could not retrieve job: %w", appErr)
+	} else if b == nil {
+		return nil, errJobNotFound
+	}
+
+	var job DumpJob
+	if err := json.Unmarshal(b, &job); err != nil {
+		return nil, fmt.Errorf("could not unmarshal job: %w", err)
+	}
+
+	return &job, nil
+}
+
+// ListJobs returns the jobs matching the filter, newest first.
+func (p *Plugin) ListJobs(ctx context.Context, filter JobFilter) (*JobList, error) {
+	index, _, err := p.getJobIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	var matching []jobIndexEntry
+	for _, e := range index {
+		if filter.matches(e) {
+			matching = append(matching, e)
+		}
+	}
+
+	list := &JobList{
+		Jobs:  []*DumpJob{},
+		Total: len(matching),
+	}
+
+	if filter.PerPage > 0 {
+		start := filter.Page * filter.PerPage
+		if start >= len(matching) {
+			return list, nil
+		}
+		matching = matching[start:min(start+filter.PerPage, len(matching))]
+	}
+
+	var missing []string
+	for _, e := range matching {
+		job, err := p.GetJob(ctx, e.ID)
+		if errors.Is(err, errJobNotFound) {
+			// the job is removed after the index is read, or its index entry is left
+			// behind by an index update racing with the removal
+			missing = append(missing, e.ID)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		list.Jobs = append(list.Jobs, job)
+	}
+
+	if len(missing) > 0 {
+		list.Total -= len(missing)
+		if err = p.removeMissingIndexEntries(missing); err != nil {
+			p.API.LogWarn("could not remove the index entries of the missing jobs", "err", err)
+		}
+	}
+
+	return list, nil
+}
+
+// forEachJob calls fn for each job matching the filter, newest first, until fn returns false.
+// The jobs are read page by page, filter.PerPage jobs at once or maxJobsPerPage if it's not
+// set. The pages are split by the creation time rather than the page number, so that the
+// jobs created or removed in the meantime don't shift the pages.
+func (p *Plugin) forEachJob(ctx context.Context, filter JobFilter, fn func(*DumpJob) bool) error {
+	perPage := filter.PerPage
+	if perPage == 0 {
+		perPage = maxJobsPerPage
+	}
+	filter.Page = 0
+	filter.PerPage = perPage
+
+	seen := make(map[string]bool)
+	for {
+		list, err := p.ListJobs(ctx, filter)
+		if err != nil {
+			return err
+		}
+
+		found := false
+		for _, job := range list.Jobs {
+			if seen[job.ID] {
+				continue
+			}
+			seen[job.ID] = true
+			found = true
+
+			if !fn(job) {
+				return nil
+			}
+		}
+
+		if !found {
+			return nil
+		}
+
+		// the next page starts at the creation time of the last job, the jobs created at
+		// that time are listed again and skipped, hence the page is extended by their number.
+		last := list.Jobs[len(list.Jobs)-1].CreateAt
+		atLast := 0
+		for _, job := range list.Jobs {
+			if job.CreateAt == last {
+				atLast++
+			}
+		}
+		filter.CreatedBefore = last
+		filter.PerPage = perPage + atLast
+	}
+}
+
+// listAllJobs returns all jobs matching the filter, newest first, see forEachJob.
+func (p *Plugin) listAllJobs(ctx context.Context, filter JobFilter) ([]*DumpJob, error) {
+	var jobs []*DumpJob
+	err := p.forEachJob(ctx, filter, func(job *DumpJob) bool {
+		jobs = append(jobs, job)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+// saveJob stores the job and updates the index if the job is new or its status is changed.
+// If onlyExisting is set, the job is not stored if it's removed from the store in the
+// meantime, e.g. it's deleted while in progress.
+func (p *Plugin) saveJob(job *DumpJob, onlyExisting bool) error {
+	_, err := p.saveJobIf(job, func(prev *DumpJob) bool {
+		return prev != nil || !onlyExisting
+	})
+	return err
+}
+
+// saveJobIf stores the job if cond returns true for the stored job, which is nil if the job is
+// not in the store. The stored job is compared and swapped, hence the job is not stored if it's
+// modified in the meantime and cond doesn't hold anymore. It returns whether the job is stored.
+func (p *Plugin) saveJobIf(job *DumpJob, cond func(prev *DumpJob) bool) (bool, error) {
+	b, err := json.Marshal(job)
+	if err != nil {
+		return false, fmt.Errorf("could not marshal job: %w", err)
+	}
+
+	var prev *DumpJob
+	for attempt := 0; ; attempt++ {
+		if attempt == maxCompareAndSetAttempts {
+			return false, fmt.Errorf("could not store job: %w", errConcurrentModification)
+		}
+
+		old, appErr := p.API.KVGet(jobKey(job.ID))
+		if appErr != nil {
+			return false, fmt.Errorf("could not retrieve job: %w", appErr)
+		}
+
+		prev = nil
+		if old != nil {
+			if err = json.Unmarshal(old, &prev); err != nil {
+				return false, fmt.Errorf("could not unmarshal job: %w", err)
+			}
+		}
+
+		if !cond(prev) {
+			return false, nil
+		}
+
+		ok, appErr := p.API.KVCompareAndSet(jobKey(job.ID), old, b)
+		if appErr != nil {
+			return false, fmt.Errorf("could not store job: %w", appErr)
+		} else if ok {
+			break
+		}
+	}
+
+	if prev != nil && prev.Status == job.Status && prev.CreateAt == job.CreateAt {
+		return true, nil
+	}
+
+	return true, p.modifyJobIndex(func(index []jobIndexEntry) []jobIndexEntry {
+		index = removeIndexEntry(index, job.ID)
+		return append(index, jobIndexEntry{
+			ID:       job.ID,
+			CreateAt: job.CreateAt,
+			Status:   job.Status,
+		})
+	})
+}
+
+// removeJob removes the job from the store, the files of the job are not removed.
+func (p *Plugin) removeJob(id string) error {
+	if appErr := p.API.KVDelete(jobKey(id)); appErr != nil {
+		return fmt.Errorf("could not delete job: %w", appErr)
+	}
+
+	return p.modifyJobIndex(func(index []jobIndexEntry) []jobIndexEntry {
+		return removeIndexEntry(index, id)
+	})
+}
+
+// removeMissingIndexEntries removes the index entries of the jobs those are not in the store.
+// The jobs are read again while modifying the index, a job saved in the meantime is kept.
+func (p *Plugin) removeMissingIndexEntries(ids []string) error {
+	return p.modifyJobIndex(func(index []jobIndexEntry) []jobIndexEntry {
+		for _, id := range ids {
+			if b, appErr := p.API.KVGet(jobKey(id)); appErr == nil && b == nil {
+				index = removeIndexEntry(index, id)
+			}
+		}
+		return index
+	})
+}
+
+// reconcileJobIndex is called periodically by a cluster job. It compares the index with the
+// stored jobs, and repairs the entries left behind by a removed job, the missing entries and
+// the entries with an outdated status, e.g. if an index update failed after storing the job.
+func (p *Plugin) reconcileJobIndex() {
+	var ids []string
+	for page := 0; ; page++ {
+		keys, appErr := p.API.KVList(page, kvListPerPage)
+		if appErr != nil {
+			p.API.LogError("could not list the stored jobs", "err", appErr)
+			return
+		}
+
+		for _, key := range keys {
+			if id, ok := strings.CutPrefix(key, KVStoreJobPrefix); ok {
+				ids = append(ids, id)
+			}
+		}
+
+		if len(keys) < kvListPerPage {
+			break
+		}
+	}
+
+	var repaired int
+	err := p.modifyJobIndex(func(index []jobIndexEntry) []jobIndexEntry {
+		repaired = 0
+		entries := make(map[string]jobIndexEntry, len(index))
+		all := make([]string, 0, len(index)+len(ids))
+		for _, e := range index {
+			entries[e.ID] = e
+			all = append(all, e.ID)
+		}
+		for _, id := range ids {
+			if _, ok := entries[id]; !ok {
+				all = append(all, id)
+			}
+		}
+
+		// the jobs are read while modifying the index, so that the jobs saved or removed
+		// after the keys are listed are not reverted
+		reconciled := make([]jobIndexEntry, 0, len(all))
+		for _, id := range all {
+			e, indexed := entries[id]
+			job, err := p.GetJob(context.TODO(), id)
+			if errors.Is(err, errJobNotFound) {
+				if indexed {
+					repaired++
+				}
+				continue
+			} else if err != nil {
+				// the entry is kept as is if the job can't be read
+				if indexed {
+					reconciled = append(reconciled, e)
+				}
+				continue
+			}
+
+			actual := jobIndexEntry{ID: job.ID, CreateAt: job.CreateAt, Status: job.Status}
+			if !indexed || e != actual {
+				repaired++
+			}
+			reconciled = append(reconciled, actual)
+		}
+
+		return reconciled
+	})
+	if err != nil {
+		p.API.LogError("could not reconcile the job index", "err", err)
+		return
+	}
+
+	if repaired > 0 {
+		p.API.LogInfo("Job index entries repaired", "count", repaired)
+	}
+}
+
+func removeIndexEntry(index []jobIndexEntry, id string) []jobIndexEntry {
+	for i, e := range index {
+		if e.ID == id {
+			return append(index[:i], index[i+1:]...)
+		}
+	}
+	return index
+}
+
+// getJobIndex returns the index sorted by the creation time, newest first, and the stored
+// value of the index to be used to modify it.
+func (p *Plugin) getJobIndex() ([]jobIndexEntry, []byte, error) {
+	b, appErr := p.API.KVGet(KVStoreJobIndexKey)
+	if appErr != nil {
+		return nil, nil, fmt.Errorf("could not retrieve job index: %w", appErr)
+	}
+
+	var index []jobIndexEntry
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &index); err != nil {
+			return nil, nil, fmt.Errorf("could not unmarshal job index: %w", err)
+		}
+	}
+
+	return index, b, nil
+}
+
+// modifyJobIndex applies fn to the index. The index is modified with compare and set, so
+// that the concurrent modifications don't require a lock.
+func (p *Plugin) modifyJobIndex(fn func([]jobIndexEntry) []jobIndexEntry) error {
+	for attempt := 0; attempt < maxCompareAndSetAttempts; attempt++ {
+		index, old, err := p.getJobIndex()
+		if err != nil {
+			return err
+		}
+
+		index = fn(index)
+		sort.SliceStable(index, func(i, j int) bool {
+			return index[i].CreateAt > index[j].CreateAt
+		})
+
+		b, err := json.Marshal(index)
+		if err != nil {
+			return fmt.Errorf("could not marshal job index: %w", err)
+		}
+
+		if bytes.Equal(old, b) {
+			return nil
+		}
+
+		ok, appErr := p.API.KVCompareAndSet(KVStoreJobIndexKey, old, b)
+		if appErr != nil {
+			return fmt.Errorf("could not store job index: %w", appErr)
+		} else if ok {
+			return nil
+		}
+
+		// wait a bit before retrying to reduce the contention
+		time.Sleep(time.Duration(attempt+1) * 10 * time.Millisecond)
+	}
+
+	return fmt.Errorf("could not store job index: %w", errConcurrentModification)
+}
+
+// migrateJobStore moves the jobs stored in a single key by the previous versions into the
+// job store. The jobs scheduled by the previous versions are added to the store as well.
+func (p *Plugin) migrateJobStore(ctx context.Context) error {
+	unlock, err := p.lockJobKVMutex(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	legacy, appErr := p.API.KVGet(KVStoreJobKey)
+	if appErr != nil {
+		return fmt.Errorf("could not retrieve jobs: %w", appErr)
+	}
+
+	jobs := make(map[string]*DumpJob)
+	if len(legacy) > 0 {
+		if err = json.Unmarshal(legacy, &jobs); err != nil {
+			return fmt.Errorf("could not unmarshal jobs: %w", err)
+		}
+	}
+
+	metas, err := p.scheduler.ListScheduledJobs()
+	if err != nil {
+		return fmt.Errorf("could not list scheduled jobs: %w", err)
+	}
+
+	for _, meta := range metas {
+		if _, ok := jobs[meta.Key]; ok {
+			continue
+		}
+
+		b, err := json.Marshal(meta.Props)
+		if err != nil {
+			p.API.LogWarn("could not marshal props", "id", meta.Key, "err", err)
+			continue
+		}
+
+		var job DumpJob
+		if err = json.Unmarshal(b, &job); err != nil || job.ID == "" {
+			// the props are not a DumpJob compatible type
+			continue
+		}
+
+		if _, err = p.GetJob(ctx, job.ID); !errors.Is(err, errJobNotFound) {
+			continue
+		}
+		job.Status = model.JobStatusPending
+		jobs[job.ID] = &job
+	}
+
+	for _, job := range jobs {
+		if err = p.saveJob(job, false); err != nil {
+			return err
+		}
+	}
+
+	if len(legacy) > 0 {
+		if appErr = p.API.KVDelete(KVStoreJobKey); appErr != nil {
+			return fmt.Errorf("could not delete the migrated jobs: %w", appErr)
+		}
+	}
+
+	if len(jobs) > 0 {
+		p.API.LogInfo("Jobs migrated to the job store", "count", len(jobs))
+	}
+
+	return nil
+}
@@ -0,0 +1,248 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	pluginmocks "github.com/mattermost/mattermost-plugin-metrics/server/mocks/github.com/mattermost/mattermost/server/public/plugin"
+)
+
+// newKVStoreMock returns a mock API backed by an in memory KV store.
+func newKVStoreMock() *pluginmocks.MockAPI {
+	var mut sync.Mutex
+	store := make(map[string][]byte)
+
+	api := &pluginmocks.MockAPI{}
+	api.On("KVGet", mock.Anything).Return(func(key string) ([]byte, *model.AppError) {
+		mut.Lock()
+		defer mut.Unlock()
+		return store[key], nil
+	})
+	api.On("KVDelete", mock.Anything).Return(func(key string) *model.AppError {
+		mut.Lock()
+		defer mut.Unlock()
+		delete(store, key)
+		return nil
+	})
+	api.On("KVCompareAndSet", mock.Anything, mock.Anything, mock.Anything).Return(func(key string, oldValue, newValue []byte) (bool, *model.AppError) {
+		mut.Lock()
+		defer mut.Unlock()
+		current, ok := store[key]
+		if (oldValue == nil && ok) || (oldValue != nil && !bytes.Equal(current, oldValue)) {
+			return false, nil
+		}
+		store[key] = newValue
+		return true, nil
+	})
+	api.On("KVList", mock.Anything, mock.Anything).Return(func(page, perPage int) ([]string, *model.AppError) {
+		mut.Lock()
+		defer mut.Unlock()
+		keys := make([]string, 0, len(store))
+		for key := range store {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		start := min(page*perPage, len(keys))
+		return keys[start:min(start+perPage, len(keys))], nil
+	})
+
+	return api
+}
+
+func TestJobStore(t *testing.T) {
+	plugin := &Plugin{}
+	plugin.SetAPI(newKVStoreMock())
+	ctx := context.Background()
+
+	statuses := []string{model.JobStatusSuccess, model.JobStatusError, model.JobStatusSuccess, model.JobStatusPending}
+	for i, status := range statuses {
+		require.NoError(t, plugin.saveJob(&DumpJob{
+			ID:       model.NewId(),
+			Status:   status,
+			CreateAt: int64(i + 1),
+		}, false))
+	}
+
+	t.Run("list all jobs", func(t *testing.T) {
+		list, err := plugin.ListJobs(ctx, JobFilter{})
+		require.NoError(t, err)
+		require.Equal(t, 4, list.Total)
+		require.Len(t, list.Jobs, 4)
+		for i, job := range list.Jobs {
+			// newest first
+			require.Equal(t, int64(4-i), job.CreateAt)
+		}
+	})
+
+	t.Run("filter and paginate", func(t *testing.T) {
+		list, err := plugin.ListJobs(ctx, JobFilter{
+			Statuses: []string{model.JobStatusSuccess},
+			PerPage:  1,
+			Page:     1,
+		})
+		require.NoError(t, err)
+		require.Equal(t, 2, list.Total)
+		require.Len(t, list.Jobs, 1)
+		require.Equal(t, int64(1), list.Jobs[0].CreateAt)
+
+		list, err = plugin.ListJobs(ctx, JobFilter{
+			CreatedAfter:  2,
+			CreatedBefore: 3,
+		})
+		require.NoError(t, err)
+		require.Equal(t, 2, list.Total)
+
+		list, err = plugin.ListJobs(ctx, JobFilter{PerPage: 10, Page: 1})
+		require.NoError(t, err)
+		require.Equal(t, 4, list.Total)
+		require.Empty(t, list.Jobs)
+	})
+
+	t.Run("update job status", func(t *testing.T) {
+		list, err := plugin.ListJobs(ctx, JobFilter{Statuses: []string{model.JobStatusPending}})
+		require.NoError(t, err)
+		require.Len(t, list.Jobs, 1)
+
+		job := list.Jobs[0]
+		job.Status = model.JobStatusInProgress
+		require.NoError(t, plugin.saveJob(job, true))
+
+		list, err = plugin.ListJobs(ctx, JobFilter{Statuses: []string{model.JobStatusInProgress}})
+		require.NoError(t, err)
+		require.Len(t, list.Jobs, 1)
+		require.Equal(t, job.ID, list.Jobs[0].ID)
+	})
+
+	t.Run("removed job is not stored again", func(t *testing.T) {
+		job := &DumpJob{
+			ID:       model.NewId(),
+			Status:   model.JobStatusInProgress,
+			CreateAt: 5,
+		}
+		require.NoError(t, plugin.saveJob(job, false))
+		require.NoError(t, plugin.removeJob(job.ID))
+
+		_, err := plugin.GetJob(ctx, job.ID)
+		require.ErrorIs(t, err, errJobNotFound)
+
+		job.Status = model.JobStatusSuccess
+		require.NoError(t, plugin.saveJob(job, true))
+
+		_, err = plugin.GetJob(ctx, job.ID)
+		require.ErrorIs(t, err, errJobNotFound)
+
+		list, err := plugin.ListJobs(ctx, JobFilter{})
+		require.NoError(t, err)
+		require.Equal(t, 4, list.Total)
+	})
+}
+
+func TestJobIndexRepair(t *testing.T) {
+	api := newKVStoreMock()
+	api.On("LogInfo", mock.Anything, mock.Anything, mock.Anything).Maybe()
+	plugin := &Plugin{}
+	plugin.SetAPI(api)
+	ctx := context.Background()
+
+	var jobs []*DumpJob
+	for i := 0; i < 3; i++ {
+		job := &DumpJob{
+			ID:       model.NewId(),
+			Status:   model.JobStatusSuccess,
+			CreateAt: int64(i + 1),
+		}
+		require.NoError(t, plugin.saveJob(job, false))
+		jobs = append(jobs, job)
+	}
+
+	// an index update racing with the removal leaves the entry of a removed job behind
+	removedID := model.NewId()
+	require.NoError(t, plugin.modifyJobIndex(func(index []jobIndexEntry) []jobIndexEntry {
+		return append(index, jobIndexEntry{ID: removedID, CreateAt: 4, Status: model.JobStatusInProgress})
+	}))
+
+	t.Run("list skips and removes the missing jobs", func(t *testing.T) {
+		list, err := plugin.ListJobs(ctx, JobFilter{})
+		require.NoError(t, err)
+		require.Equal(t, 3, list.Total)
+		require.Len(t, list.Jobs, 3)
+
+		index, _, err := plugin.getJobIndex()
+		require.NoError(t, err)
+		require.Len(t, index, 3)
+	})
+
+	t.Run("reconcile", func(t *testing.T) {
+		require.NoError(t, plugin.modifyJobIndex(func(index []jobIndexEntry) []jobIndexEntry {
+			return append(index, jobIndexEntry{ID: removedID, CreateAt: 4, Status: model.JobStatusInProgress})
+		}))
+
+		// the index updates of a new job and of a status change failed
+		unindexed := &DumpJob{ID: model.NewId(), Status: model.JobStatusPending, CreateAt: 5}
+		b, err := json.Marshal(unindexed)
+		require.NoError(t, err)
+		_, appErr := api.KVCompareAndSet(jobKey(unindexed.ID), nil, b)
+		require.Nil(t, appErr)
+
+		old, appErr := api.KVGet(jobKey(jobs[0].ID))
+		require.Nil(t, appErr)
+		jobs[0].Status = model.JobStatusError
+		b, err = json.Marshal(jobs[0])
+		require.NoError(t, err)
+		_, appErr = api.KVCompareAndSet(jobKey(jobs[0].ID), old, b)
+		require.Nil(t, appErr)
+
+		plugin.reconcileJobIndex()
+
+		index, _, err := plugin.getJobIndex()
+		require.NoError(t, err)
+		require.Equal(t, []jobIndexEntry{
+			{ID: unindexed.ID, CreateAt: 5, Status: model.JobStatusPending},
+			{ID: jobs[2].ID, CreateAt: 3, Status: model.JobStatusSuccess},
+			{ID: jobs[1].ID, CreateAt: 2, Status: model.JobStatusSuccess},
+			{ID: jobs[0].ID, CreateAt: 1, Status: model.JobStatusError},
+		}, index)
+	})
+}
+
+func TestForEachJob(t *testing.T) {
+	plugin := &Plugin{}
+	plugin.SetAPI(newKVStoreMock())
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, plugin.saveJob(&DumpJob{
+			ID:       model.NewId(),
+			Status:   model.JobStatusSuccess,
+			CreateAt: int64(i/2 + 1),
+		}, false))
+	}
+
+	var createAt []int64
+	err := plugin.forEachJob(ctx, JobFilter{PerPage: 2}, func(job *DumpJob) bool {
+		createAt = append(createAt, job.CreateAt)
+		return true
+	})
+	require.NoError(t, err)
+	// the jobs sharing the creation time at the end of a page are listed once
+	require.Equal(t, []int64{3, 2, 2, 1, 1}, createAt)
+
+	var visited int
+	err = plugin.forEachJob(ctx, JobFilter{PerPage: 2}, func(*DumpJob) bool {
+		visited++
+		return visited < 3
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, visited)
+}
@@ -5,9 +5,12 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/v8/platform/shared/filestore"
 	"github.com/stretchr/testify/require"
 )
@@ -62,3 +65,205 @@ func TestDeleteDump(t *testing.T) {
 		require.Equal(t, "random text", string(b))
 	})
 }
+
+func TestIsStale(t *testing.T) {
+	now := time.Now()
+	timeout := time.Hour
+	startAt := now.Add(-timeout - staleJobGracePeriod - time.Minute).UnixMilli()
+
+	for _, tc := range []struct {
+		name    string
+		job     DumpJob
+		timeout time.Duration
+		stale   bool
+	}{
+		{
+			name:    "in progress job exceeding the timeout",
+			job:     DumpJob{Status: model.JobStatusInProgress, StartAt: startAt},
+			timeout: timeout,
+			stale:   true,
+		},
+		{
+			name:    "in progress job within the grace period",
+			job:     DumpJob{Status: model.JobStatusInProgress, StartAt: now.Add(-timeout - time.Minute).UnixMilli()},
+			timeout: timeout,
+		},
+		{
+			name:    "job without the start time",
+			job:     DumpJob{Status: model.JobStatusInProgress, CreateAt: startAt},
+			timeout: timeout,
+			stale:   true,
+		},
+		{
+			name:    "finished job",
+			job:     DumpJob{Status: model.JobStatusSuccess, StartAt: startAt},
+			timeout: timeout,
+		},
+		{
+			name: "no timeout",
+			job:  DumpJob{Status: model.JobStatusInProgress, StartAt: startAt},
+		},
+		{
+			name: "job with a recent heartbeat",
+			job: DumpJob{Status: model.JobStatusInProgress, StartAt: startAt, Progress: &JobProgress{
+				UpdateAt: now.Add(-jobHeartbeatInterval).UnixMilli(),
+			}},
+			timeout: timeout,
+		},
+		{
+			name: "job with a missed heartbeat",
+			job: DumpJob{Status: model.JobStatusInProgress, StartAt: now.Add(-10 * time.Minute).UnixMilli(), Progress: &JobProgress{
+				UpdateAt: now.Add(-staleJobHeartbeatTimeout - time.Minute).UnixMilli(),
+			}},
+			timeout: timeout,
+			stale:   true,
+		},
+		{
+			name: "job with a missed heartbeat and no timeout",
+			job: DumpJob{Status: model.JobStatusInProgress, StartAt: now.Add(-10 * time.Minute).UnixMilli(), Progress: &JobProgress{
+				UpdateAt: now.Add(-staleJobHeartbeatTimeout - time.Minute).UnixMilli(),
+			}},
+			stale: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.stale, tc.job.isStale(now, tc.timeout))
+		})
+	}
+}
+
+func TestResetJobResults(t *testing.T) {
+	failed := func(jobType string) *DumpJob {
+		return &DumpJob{
+			ID:           "id",
+			Status:       model.JobStatusError,
+			CreateAt:     10,
+			MinT:         100,
+			MaxT:         200,
+			DumpLocation: filepath.Join(pluginDataDir, PluginName, "dump", "id", zipFileName),
+			Matchers:     []string{`{job="calls"}`},
+			Format:       DumpFormatOpenMetrics,
+			Type:         jobType,
+			Checksum:     "checksum",
+			Encrypted:    true,
+			CreatorID:    "creator",
+			Delivery:     &DumpDelivery{Type: DeliveryTypeChannel, ChannelID: "channel", DeliveredAt: 20, Error: "delivery error"},
+			Size:         1024,
+			Resolution:   5 * time.Minute.Milliseconds(),
+			Progress:     &JobProgress{Phase: JobPhaseUpload},
+			Error:        "error",
+			StartAt:      30,
+			FailedPhase:  JobPhaseUpload,
+		}
+	}
+
+	t.Run("dump", func(t *testing.T) {
+		job := failed(JobTypeDump)
+		job.resetResults()
+		require.Equal(t, &DumpJob{
+			ID:        "id",
+			Status:    model.JobStatusError,
+			CreateAt:  10,
+			MinT:      100,
+			MaxT:      200,
+			Matchers:  []string{`{job="calls"}`},
+			Format:    DumpFormatOpenMetrics,
+			Type:      JobTypeDump,
+			CreatorID: "creator",
+			Delivery:  &DumpDelivery{Type: DeliveryTypeChannel, ChannelID: "channel"},
+		}, job)
+	})
+
+	t.Run("import", func(t *testing.T) {
+		job := failed(JobTypeImport)
+		job.DumpLocation = filepath.Join(importDir("id"), zipFileName)
+		job.resetResults()
+		// the uploaded archive is imported again
+		require.Equal(t, &DumpJob{
+			ID:           "id",
+			Status:       model.JobStatusError,
+			CreateAt:     10,
+			DumpLocation: filepath.Join(importDir("id"), zipFileName),
+			Matchers:     []string{`{job="calls"}`},
+			Format:       DumpFormatOpenMetrics,
+			Type:         JobTypeImport,
+			CreatorID:    "creator",
+			Delivery:     &DumpDelivery{Type: DeliveryTypeChannel, ChannelID: "channel"},
+		}, job)
+	})
+}
+
+func TestFailStaleJobConcurrently(t *testing.T) {
+	plugin := &Plugin{}
+	plugin.SetAPI(newKVStoreMock())
+	ctx := context.Background()
+
+	startAt := time.Now().Add(-time.Hour).UnixMilli()
+	inProgress := func(id string) *DumpJob {
+		return &DumpJob{
+			ID:       id,
+			Status:   model.JobStatusInProgress,
+			CreateAt: startAt,
+			StartAt:  startAt,
+			Progress: &JobProgress{Phase: JobPhaseCompact, UpdateAt: startAt},
+		}
+	}
+
+	t.Run("job completed after it's read", func(t *testing.T) {
+		require.NoError(t, plugin.saveJob(inProgress("completed"), false))
+
+		// the stale jobs check reads the job, then the job completes
+		read, err := plugin.GetJob(ctx, "completed")
+		require.NoError(t, err)
+		require.True(t, read.isStale(time.Now(), 0))
+
+		completed := inProgress("completed")
+		completed.Status = model.JobStatusSuccess
+		completed.Progress = nil
+		stored, err := plugin.saveJobIf(completed, ownedByRun(completed, startAt))
+		require.NoError(t, err)
+		require.True(t, stored)
+
+		plugin.failStaleJob(read, 0)
+
+		job, err := plugin.GetJob(ctx, "completed")
+		require.NoError(t, err)
+		require.Equal(t, model.JobStatusSuccess, job.Status)
+		require.Empty(t, job.Error)
+	})
+
+	t.Run("heartbeat after it's read", func(t *testing.T) {
+		require.NoError(t, plugin.saveJob(inProgress("heartbeat"), false))
+
+		read, err := plugin.GetJob(ctx, "heartbeat")
+		require.NoError(t, err)
+
+		running := inProgress("heartbeat")
+		running.Progress.UpdateAt = time.Now().UnixMilli()
+		stored, err := plugin.saveJobIf(running, ownedByRun(running, startAt))
+		require.NoError(t, err)
+		require.True(t, stored)
+
+		plugin.failStaleJob(read, 0)
+
+		job, err := plugin.GetJob(ctx, "heartbeat")
+		require.NoError(t, err)
+		require.Equal(t, model.JobStatusInProgress, job.Status)
+	})
+
+	t.Run("late heartbeat of a failed job", func(t *testing.T) {
+		failed := inProgress("failed")
+		failed.Status = model.JobStatusError
+		failed.Progress = nil
+		require.NoError(t, plugin.saveJob(failed, false))
+
+		running := inProgress("failed")
+		stored, err := plugin.saveJobIf(running, ownedByRun(running, startAt))
+		require.NoError(t, err)
+		require.False(t, stored)
+
+		job, err := plugin.GetJob(ctx, "failed")
+		require.NoError(t, err)
+		require.Equal(t, model.JobStatusError, job.Status)
+	})
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	manifestFileName = "manifest.json"
+	manifestVersion1 = 1
+)
+
+// DumpManifest describes the contents of a dump archive, it's written into the archive so
+// that corrupted or truncated archives can be detected.
+type DumpManifest struct {
+	Version       int             `json:"version"`
+	CreateAt      int64           `json:"create_at"`
+	RequestedMinT int64           `json:"requested_min_t"`
+	RequestedMaxT int64           `json:"requested_max_t"`
+	MinT          int64           `json:"min_t"`
+	MaxT          int64           `json:"max_t"`
+	Blocks        []ManifestBlock `json:"blocks"`
+	Files         []ManifestFile  `json:"files"`
+	// Resolution is the coarsest resolution of the downsampled samples in milliseconds, 0 if
+	// the dump has the raw samples only.
+	Resolution int64 `json:"resolution,omitempty"`
+}
+
+type ManifestBlock struct {
+	ULID       string `json:"ulid"`
+	MinTime    int64  `json:"min_time"`
+	MaxTime    int64  `json:"max_time"`
+	NumSeries  uint64 `json:"num_series"`
+	NumSamples uint64 `json:"num_samples"`
+}
+
+type ManifestFile struct {
+	// Path is the slash separated path of the file within the archive.
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// ManifestVerification is the result of verifying an archive against its manifest.
+type ManifestVerification struct {
+	Valid      bool     `json:"valid"`
+	Error      string   `json:"error,omitempty"`
+	Checksum   string   `json:"checksum"`
+	Missing    []string `json:"missing,omitempty"`
+	Mismatched []string `json:"mismatched,omitempty"`
+	Unexpected []string `json:"unexpected,omitempty"`
+}
+
+// verifyArchive reads the whole tar.gz archive and compares its files with the manifest
+// in the archive. Archives those can't be read until the end, e.g. truncated ones, are
+// reported as invalid.
+func verifyArchive(r io.Reader) (*ManifestVerification, error) {
+	archiveHash := sha256.New()
+	result := &ManifestVerification{}
+
+	checksums, manifest, err := readArchiveChecksums(io.TeeReader(r, archiveHash))
+	if err != nil {
+		result.Error = err.Error()
+		return result, nil
+	}
+
+	// drain the remaining padding so that the checksum is of the whole archive
+	if _, err = io.Copy(archiveHash, r); err != nil {
+		return nil, err
+	}
+	result.Checksum = hex.EncodeToString(archiveHash.Sum(nil))
+
+	if manifest == nil {
+		result.Error = "the archive does not contain a manifest"
+		return result, nil
+	}
+
+	for _, file := range manifest.Files {
+		checksum, ok := checksums[file.Path]
+		if !ok {
+			result.Missing = append(result.Missing, file.Path)
+			continue
+		}
+		delete(checksums, file.Path)
+
+		if checksum != file.SHA256 {
+			result.Mismatched = append(result.Mismatched, file.Path)
+		}
+	}
+
+	for path := range checksums {
+		result.Unexpected = append(result.Unexpected, path)
+	}
+	sort.Strings(result.Unexpected)
+
+	result.Valid = len(result.Missing) == 0 && len(result.Mismatched) == 0 && len(result.Unexpected) == 0
+
+	return result, nil
+}
+
+// readArchiveChecksums returns the checksums of the regular files in the archive keyed by
+// their slash separated paths, and the manifest if the archive contains one.
+func readArchiveChecksums(r io.Reader) (map[string]string, *DumpManifest, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read the archive: %w", err)
+	}
+	defer zr.Close()
+
+	checksums := make(map[string]string)
+	var manifest *DumpManifest
+
+	tr := tar.NewReader(zr)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("could not read the archive: %w", err)
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		path := strings.TrimPrefix(filepath.ToSlash(filepath.Clean("/"+header.Name)), "/")
+		if path == manifestFileName {
+			manifest = &DumpManifest{}
+			if err = json.NewDecoder(tr).Decode(manifest); err != nil {
+				return nil, nil, fmt.Errorf("could not decode the manifest: %w", err)
+			}
+			continue
+		}
+
+		h := sha256.New()
+		if _, err = io.Copy(h, tr); err != nil {
+			return nil, nil, fmt.Errorf("could not read %s: %w", path, err)
+		}
+		checksums[path] = hex.EncodeToString(h.Sum(nil))
+	}
+
+	return checksums, manifest, nil
+}
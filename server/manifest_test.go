@@ -0,0 +1,143 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/mattermost/mattermost/server/v8/platform/shared/filestore"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDumpManifest(t *testing.T) {
+	maxt := time.Now().Truncate(time.Minute).UnixMilli()
+	mint := maxt - time.Hour.Milliseconds()
+
+	db := createTestTSDB(t, mint, maxt, labels.FromStrings(labels.MetricName, "rtc_sessions", "job", "calls"))
+	q, err := db.Querier(mint, maxt)
+	require.NoError(t, err)
+	defer q.Close()
+
+	dumpDir := filepath.Join(t.TempDir(), "data")
+	err = rewriteTSDB(context.Background(), log.NewNopLogger(), q, dumpDir, rewriteOptions{
+		mint:          mint,
+		maxt:          maxt,
+		blockDuration: 3 * tsdb.DefaultBlockDuration,
+	})
+	require.NoError(t, err)
+
+	fs, err := filestore.NewFileBackend(filestore.FileBackendSettings{
+		DriverName: "local",
+		Directory:  t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	plugin := &Plugin{
+		fileBackend: fs,
+	}
+
+	aw, err := plugin.newArchiveWriter(zipFileName, nil, nil)
+	require.NoError(t, err)
+	require.NoError(t, aw.addDirectory(dumpDir, ""))
+
+	checksum, err := aw.close(&DumpManifest{
+		Version:       manifestVersion1,
+		RequestedMinT: mint - 1000,
+		RequestedMaxT: maxt + 1000,
+		MinT:          mint,
+		MaxT:          maxt,
+	})
+	require.NoError(t, err)
+
+	archive, err := fs.ReadFile(zipFileName)
+	require.NoError(t, err)
+
+	sum := sha256.Sum256(archive)
+	require.Equal(t, hex.EncodeToString(sum[:]), checksum)
+
+	extracted := t.TempDir()
+	require.NoError(t, extractArchive(context.Background(), bytes.NewReader(archive), extracted, math.MaxInt64))
+
+	b, err := os.ReadFile(filepath.Join(extracted, manifestFileName))
+	require.NoError(t, err)
+
+	var manifest DumpManifest
+	require.NoError(t, json.Unmarshal(b, &manifest))
+	require.Equal(t, mint-1000, manifest.RequestedMinT)
+	require.Equal(t, maxt, manifest.MaxT)
+	require.NotEmpty(t, manifest.Files)
+	for _, file := range manifest.Files {
+		require.Len(t, file.SHA256, 64)
+	}
+
+	t.Run("valid archive", func(t *testing.T) {
+		result, err := verifyArchive(bytes.NewReader(archive))
+		require.NoError(t, err)
+		require.True(t, result.Valid, result)
+		require.Equal(t, checksum, result.Checksum)
+	})
+
+	t.Run("truncated archive", func(t *testing.T) {
+		result, err := verifyArchive(bytes.NewReader(archive[:len(archive)/2]))
+		require.NoError(t, err)
+		require.False(t, result.Valid)
+		require.NotEmpty(t, result.Error)
+	})
+
+	t.Run("modified file", func(t *testing.T) {
+		file := manifest.Files[0].Path
+		require.NoError(t, os.WriteFile(filepath.Join(extracted, file), []byte("{}"), 0600))
+
+		result, err := verifyArchive(bytes.NewReader(createTestArchive(t, extracted)))
+		require.NoError(t, err)
+		require.False(t, result.Valid)
+		require.Equal(t, []string{file}, result.Mismatched)
+	})
+}
+
+func TestArchiveWriterUploadFailure(t *testing.T) {
+	fs, err := filestore.NewFileBackend(filestore.FileBackendSettings{
+		DriverName: "local",
+		Directory:  t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	// the file store fails once the whole archive is written
+	plugin := &Plugin{
+		fileBackend: &truncatingBackend{FileBackend: fs, path: zipFileName},
+	}
+
+	aw, err := plugin.newArchiveWriter(zipFileName, nil, nil)
+	require.NoError(t, err)
+	require.NoError(t, aw.addFile("file", bytes.NewReader([]byte("contents")), 8, time.Now()))
+
+	_, err = aw.close(&DumpManifest{Version: manifestVersion1})
+	require.Error(t, err)
+
+	// aborting after a failed close returns instead of waiting for the upload again
+	done := make(chan struct{})
+	go func() {
+		aw.abort(err)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "abort did not return after a failed close")
+	}
+	require.ErrorContains(t, aw.wait(), "connection reset")
+}
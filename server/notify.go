@@ -0,0 +1,242 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/units"
+	"github.com/prometheus/prometheus/scrape"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	botUsername    = "metrics"
+	botDisplayName = "Metrics"
+	botDescription = "Posts the notifications of the Mattermost Metrics Plugin."
+
+	// targetHealthCheckInterval is the period of checking the health of the scrape targets.
+	targetHealthCheckInterval = time.Minute
+	// targetDownThreshold is the number of the consecutive failed checks before a target is
+	// reported down, and targetUpThreshold is the number of the consecutive successful checks
+	// before it's reported up again, so that a single failed scrape is not reported.
+	targetDownThreshold = 3
+	targetUpThreshold   = 2
+	// targetNotifyMinInterval is the minimum interval between the outage notifications of a
+	// target, the outages of a flapping target within the interval are only logged.
+	targetNotifyMinInterval = 30 * time.Minute
+)
+
+func (p *Plugin) ensureBot() error {
+	botID, err := p.client.Bot.EnsureBot(&model.Bot{
+		Username:    botUsername,
+		DisplayName: botDisplayName,
+		Description: botDescription,
+	})
+	if err != nil {
+		return fmt.Errorf("could not ensure the bot: %w", err)
+	}
+	p.botID = botID
+
+	return nil
+}
+
+// notify posts the message to the notification channel as the bot. Nothing is posted if
+// the notification channel is not configured.
+func (p *Plugin) notify(message string) {
+	cfg, err := p.getConfiguration()
+	if err != nil {
+		p.API.LogWarn("could not get plugin configuration", "err", err)
+		return
+	}
+
+	channelID := *cfg.NotificationChannelID
+	if channelID == "" || p.botID == "" {
+		return
+	}
+
+	_, appErr := p.API.CreatePost(&model.Post{
+		UserId:    p.botID,
+		ChannelId: channelID,
+		Message:   message,
+	})
+	if appErr != nil {
+		p.API.LogError("could not post the notification", "channel", channelID, "err", appErr)
+	}
+}
+
+func (p *Plugin) notifyJobFinished(job *DumpJob) {
+	kind := "Dump"
+	if job.Type == JobTypeImport {
+		kind = "Import"
+	}
+
+	switch job.Status {
+	case model.JobStatusSuccess:
+		message := fmt.Sprintf("%s job `%s` from %s to %s has finished", kind, job.ID, formatMillis(job.MinT), formatMillis(job.MaxT))
+		if job.Size > 0 {
+			message += fmt.Sprintf(", the archive size is %s", units.Base2Bytes(job.Size).Round(1))
+		}
+		message += "."
+		if job.Delivery != nil && job.Delivery.Error != "" {
+			message += fmt.Sprintf(" The dump could not be delivered: %s", job.Delivery.Error)
+		}
+		p.notify(message)
+	case model.JobStatusError:
+		phase := ""
+		if job.FailedPhase != "" {
+			phase = fmt.Sprintf(" in the %s phase", job.FailedPhase)
+		}
+		p.notify(fmt.Sprintf(":warning: %s job `%s` has failed%s: %s", kind, job.ID, phase, job.Error))
+	}
+}
+
+// targetHealth is the health of a scrape target as of the last scrape.
+type targetHealth struct {
+	key       string
+	job       string
+	instance  string
+	up        bool
+	lastError string
+}
+
+// targetState is the tracked health of a scrape target.
+type targetState struct {
+	failures  int
+	successes int
+	down      bool
+	// notified is set if the current outage is notified, its recovery is notified then.
+	notified     bool
+	lastNotifyAt time.Time
+}
+
+// targetMonitor tracks the health of the scrape targets to report the outages once, when
+// the target goes down, and when the target recovers.
+type targetMonitor struct {
+	targets map[string]*targetState
+}
+
+func newTargetMonitor() *targetMonitor {
+	return &targetMonitor{
+		targets: make(map[string]*targetState),
+	}
+}
+
+// update returns the targets gone down and the targets recovered since the last update
+// those should be notified. The targets gone down are returned once those fail
+// targetDownThreshold times in a row, and at most once per targetNotifyMinInterval.
+func (m *targetMonitor) update(targets []targetHealth, now time.Time) (down, recovered []targetHealth) {
+	seen := make(map[string]bool, len(targets))
+	for _, t := range targets {
+		seen[t.key] = true
+		state, ok := m.targets[t.key]
+		if !ok {
+			state = &targetState{}
+			m.targets[t.key] = state
+		}
+
+		if !t.up {
+			state.failures++
+			state.successes = 0
+			if state.down || state.failures < targetDownThreshold {
+				continue
+			}
+
+			state.down = true
+			state.notified = now.Sub(state.lastNotifyAt) >= targetNotifyMinInterval
+			if state.notified {
+				state.lastNotifyAt = now
+				down = append(down, t)
+			}
+			continue
+		}
+
+		state.successes++
+		state.failures = 0
+		if state.down && state.successes >= targetUpThreshold {
+			state.down = false
+			if state.notified {
+				state.notified = false
+				recovered = append(recovered, t)
+			}
+		}
+	}
+
+	// the removed targets are forgotten
+	for key := range m.targets {
+		if !seen[key] {
+			delete(m.targets, key)
+		}
+	}
+
+	return down, recovered
+}
+
+func activeTargetsHealth(manager *scrape.Manager) []targetHealth {
+	var targets []targetHealth
+	for _, active := range manager.TargetsActive() {
+		for _, target := range active {
+			// the targets not scraped yet are skipped
+			if target.Health() == scrape.HealthUnknown {
+				continue
+			}
+
+			lastError := ""
+			if err := target.LastError(); err != nil {
+				lastError = err.Error()
+			}
+
+			lset := target.Labels()
+			targets = append(targets, targetHealth{
+				key:       target.URL().String(),
+				job:       lset.Get("job"),
+				instance:  lset.Get("instance"),
+				up:        target.Health() == scrape.HealthGood,
+				lastError: lastError,
+			})
+		}
+	}
+
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].key < targets[j].key
+	})
+
+	return targets
+}
+
+// monitorTargets checks the health of the scrape targets periodically and notifies about
+// the outages until the plugin is deactivated.
+func (p *Plugin) monitorTargets(manager *scrape.Manager) {
+	ticker := time.NewTicker(targetHealthCheckInterval)
+	defer ticker.Stop()
+
+	monitor := newTargetMonitor()
+	for {
+		select {
+		case <-ticker.C:
+			down, recovered := monitor.update(activeTargetsHealth(manager), time.Now())
+
+			// the changes of a check are posted at once, so that an outage of many targets
+			// doesn't flood the channel
+			var lines []string
+			for _, t := range down {
+				p.API.LogWarn("Scrape target is down", "job", t.job, "instance", t.instance, "err", t.lastError)
+				lines = append(lines, fmt.Sprintf(":red_circle: Scrape target `%s` of job `%s` is down: %s", t.instance, t.job, t.lastError))
+			}
+			for _, t := range recovered {
+				p.API.LogInfo("Scrape target is up again", "job", t.job, "instance", t.instance)
+				lines = append(lines, fmt.Sprintf(":large_green_circle: Scrape target `%s` of job `%s` is up again.", t.instance, t.job))
+			}
+			if len(lines) > 0 {
+				p.notify(strings.Join(lines, "\n"))
+			}
+		case <-p.closeChan:
+			return
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	pluginmocks "github.com/mattermost/mattermost-plugin-metrics/server/mocks/github.com/mattermost/mattermost/server/public/plugin"
+)
+
+func TestTargetMonitor(t *testing.T) {
+	monitor := newTargetMonitor()
+	now := time.Now()
+	update := func(targets ...targetHealth) ([]targetHealth, []targetHealth) {
+		now = now.Add(targetHealthCheckInterval)
+		return monitor.update(targets, now)
+	}
+
+	a := targetHealth{key: "a", up: true}
+	b := targetHealth{key: "b", up: true}
+
+	down, recovered := update(a, b)
+	require.Empty(t, down)
+	require.Empty(t, recovered)
+
+	// the outage is reported once the target fails several times in a row
+	b.up = false
+	for i := 1; i < targetDownThreshold; i++ {
+		down, _ = update(a, b)
+		require.Empty(t, down)
+	}
+	down, recovered = update(a, b)
+	require.Equal(t, []targetHealth{b}, down)
+	require.Empty(t, recovered)
+
+	// the outage is reported once
+	down, _ = update(a, b)
+	require.Empty(t, down)
+
+	b.up = true
+	for i := 1; i < targetUpThreshold; i++ {
+		_, recovered = update(a, b)
+		require.Empty(t, recovered)
+	}
+	down, recovered = update(a, b)
+	require.Empty(t, down)
+	require.Equal(t, []targetHealth{b}, recovered)
+
+	// a single failed scrape is not reported
+	b.up = false
+	update(a, b)
+	b.up = true
+	down, _ = update(a, b)
+	require.Empty(t, down)
+
+	// the outages of a flapping target are not reported again within the interval, neither
+	// its recovery
+	b.up = false
+	for i := 0; i < targetDownThreshold; i++ {
+		down, _ = update(a, b)
+		require.Empty(t, down)
+	}
+	b.up = true
+	for i := 0; i < targetUpThreshold; i++ {
+		_, recovered = update(a, b)
+		require.Empty(t, recovered)
+	}
+
+	now = now.Add(targetNotifyMinInterval)
+	b.up = false
+	for i := 1; i < targetDownThreshold; i++ {
+		update(a, b)
+	}
+	down, _ = update(a, b)
+	require.Equal(t, []targetHealth{b}, down)
+
+	// the removed targets are forgotten
+	a.up = false
+	for i := 1; i < targetDownThreshold; i++ {
+		update(a)
+	}
+	update()
+	for i := 1; i < targetDownThreshold; i++ {
+		down, _ = update(a)
+		require.Empty(t, down)
+	}
+	down, _ = update(a)
+	require.Equal(t, []targetHealth{a}, down)
+}
+
+func TestNotifyJobFinished(t *testing.T) {
+	channelID := model.NewId()
+
+	api := &pluginmocks.MockAPI{}
+	api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.UserId == "bot" && post.ChannelId == channelID
+	})).Return(&model.Post{}, nil)
+
+	cfg := new(configuration)
+	cfg.SetDefaults()
+	cfg.NotificationChannelID = model.NewString(channelID)
+
+	plugin := &Plugin{
+		botID: "bot",
+	}
+	plugin.SetAPI(api)
+	require.NoError(t, plugin.setConfiguration(cfg))
+
+	plugin.notifyJobFinished(&DumpJob{
+		ID:          "job1",
+		Status:      model.JobStatusError,
+		Error:       "no space left on device",
+		FailedPhase: JobPhaseCompact,
+	})
+	api.AssertCalled(t, "CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.Message == ":warning: Dump job `job1` has failed in the compact phase: no space left on device"
+	}))
+
+	// the canceled jobs are not notified
+	plugin.notifyJobFinished(&DumpJob{ID: "job2", Status: model.JobStatusCanceled})
+	api.AssertNumberOfCalls(t, "CreatePost", 1)
+}
@@ -0,0 +1,182 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+const (
+	DumpFormatTSDB        = "tsdb"
+	DumpFormatOpenMetrics = "openmetrics"
+
+	openMetricsZipFileName = "openmetrics_dump.tar.gz"
+)
+
+// openMetricsFileName returns the name of the OpenMetrics text file of the block starting at
+// mint. The timestamp is zero padded, hence the names sort by time.
+func openMetricsFileName(mint int64) string {
+	return fmt.Sprintf("metrics_%013d.om.txt", mint)
+}
+
+func isValidDumpFormat(format string) bool {
+	switch format {
+	case "", DumpFormatTSDB, DumpFormatOpenMetrics:
+		return true
+	}
+	return false
+}
+
+// writeOpenMetrics renders the float samples of all series within [mint, maxt] in the
+// OpenMetrics text format with timestamps. Native histograms can't be represented in the
+// text format, hence they are skipped. It returns the number of skipped samples.
+func writeOpenMetrics(ctx context.Context, q storage.Querier, w io.Writer, mint, maxt int64) (int, error) {
+	bw := bufio.NewWriter(w)
+
+	// series are sorted by their labels, hence the samples of a metric family are grouped
+	// together as the format requires.
+	set := selectSeries(ctx, q, nil, mint, maxt)
+
+	skipped := 0
+	families := make(map[string]bool)
+	current, currentType := "", ""
+	var it chunkenc.Iterator
+	for set.Next() {
+		series := set.At()
+		lset := series.Labels()
+		name := formatOpenMetricsSeries(lset)
+
+		family, typ := openMetricsFamily(lset)
+		if (family != current || typ != currentType) && families[family] {
+			// e.g. the gauge foo and the counter foo_total, the family names must be unique
+			family, typ = lset.Get(labels.MetricName), openMetricsTypeUnknown
+		}
+
+		it = series.Iterator(it)
+		for vt := it.Next(); vt != chunkenc.ValNone; vt = it.Next() {
+			if vt != chunkenc.ValFloat {
+				skipped++
+				continue
+			}
+
+			t, v := it.At()
+			if t < mint || t > maxt {
+				continue
+			}
+
+			// the type is written before the first sample of the family
+			if family != current || typ != currentType {
+				if _, err := fmt.Fprintf(bw, "# TYPE %s %s\n", family, typ); err != nil {
+					return skipped, err
+				}
+				families[family] = true
+				current, currentType = family, typ
+			}
+
+			if _, err := fmt.Fprintf(bw, "%s %s %s\n", name, formatOpenMetricsValue(v), formatOpenMetricsTimestamp(t)); err != nil {
+				return skipped, err
+			}
+		}
+		if it.Err() != nil {
+			return skipped, it.Err()
+		}
+	}
+	if set.Err() != nil {
+		return skipped, set.Err()
+	}
+
+	if _, err := bw.WriteString("# EOF\n"); err != nil {
+		return skipped, err
+	}
+
+	return skipped, bw.Flush()
+}
+
+const (
+	openMetricsTypeCounter = "counter"
+	openMetricsTypeGauge   = "gauge"
+	openMetricsTypeUnknown = "unknown"
+)
+
+// openMetricsFamily returns the metric family name and the type of the series. The tsdb blocks
+// don't keep the metric metadata, hence the type is inferred by the naming conventions: the
+// counters end with _total. The series of the classic histograms and summaries can't be grouped
+// into their families as they are not sorted together, so they are written as unknown.
+func openMetricsFamily(lset labels.Labels) (string, string) {
+	name := lset.Get(labels.MetricName)
+
+	switch {
+	case strings.HasSuffix(name, "_total") && len(name) > len("_total"):
+		return strings.TrimSuffix(name, "_total"), openMetricsTypeCounter
+	case lset.Has("le") || lset.Has("quantile"),
+		strings.HasSuffix(name, "_bucket"),
+		strings.HasSuffix(name, "_count"),
+		strings.HasSuffix(name, "_sum"),
+		strings.HasSuffix(name, "_created"):
+		return name, openMetricsTypeUnknown
+	}
+
+	return name, openMetricsTypeGauge
+}
+
+var openMetricsLabelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatOpenMetricsSeries(lset labels.Labels) string {
+	return lset.Get(labels.MetricName) + formatOpenMetricsLabels(lset)
+}
+
+// formatOpenMetricsLabels formats the labels except the metric name, e.g. `{job="calls"}`.
+func formatOpenMetricsLabels(lset labels.Labels) string {
+	var sb strings.Builder
+
+	first := true
+	lset.Range(func(l labels.Label) {
+		if l.Name == labels.MetricName {
+			return
+		}
+
+		if first {
+			sb.WriteByte('{')
+			first = false
+		} else {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(l.Name)
+		sb.WriteString(`="`)
+		sb.WriteString(openMetricsLabelValueReplacer.Replace(l.Value))
+		sb.WriteByte('"')
+	})
+	if !first {
+		sb.WriteByte('}')
+	}
+
+	return sb.String()
+}
+
+func formatOpenMetricsValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// formatOpenMetricsTimestamp formats the millisecond timestamp in seconds as the format requires.
+func formatOpenMetricsTimestamp(t int64) string {
+	return strconv.FormatFloat(float64(t)/1000, 'f', 3, 64)
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"testing"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteOpenMetrics(t *testing.T) {
+	mint := int64(1700000000000)
+	maxt := mint + 120000
+
+	goroutines := labels.FromStrings(labels.MetricName, "go_goroutines", "instance", "localhost:8067", "job", "prometheus")
+	sessions := labels.FromStrings(labels.MetricName, "rtc_sessions", "job", "calls")
+	requests := labels.FromStrings(labels.MetricName, "http_requests_total", "job", "prometheus")
+	db := createTestTSDB(t, mint, maxt, goroutines, sessions, requests)
+
+	q, err := db.Querier(mint, maxt)
+	require.NoError(t, err)
+	defer q.Close()
+
+	var buf bytes.Buffer
+	skipped, err := writeOpenMetrics(context.Background(), q, &buf, mint, mint+60000)
+	require.NoError(t, err)
+	require.Zero(t, skipped)
+
+	// createTestTSDB uses the timestamps as the sample values
+	expected := `# TYPE go_goroutines gauge
+go_goroutines{instance="localhost:8067",job="prometheus"} 1.7e+12 1700000000.000
+go_goroutines{instance="localhost:8067",job="prometheus"} 1.70000006e+12 1700000060.000
+# TYPE http_requests counter
+http_requests_total{job="prometheus"} 1.7e+12 1700000000.000
+http_requests_total{job="prometheus"} 1.70000006e+12 1700000060.000
+# TYPE rtc_sessions gauge
+rtc_sessions{job="calls"} 1.7e+12 1700000000.000
+rtc_sessions{job="calls"} 1.70000006e+12 1700000060.000
+# EOF
+`
+	require.Equal(t, expected, buf.String())
+}
+
+func TestWriteOpenMetricsDuplicateFamily(t *testing.T) {
+	mint := int64(1700000000000)
+
+	gauge := labels.FromStrings(labels.MetricName, "uploads")
+	counter := labels.FromStrings(labels.MetricName, "uploads_total")
+	db := createTestTSDB(t, mint, mint, gauge, counter)
+
+	q, err := db.Querier(mint, mint)
+	require.NoError(t, err)
+	defer q.Close()
+
+	var buf bytes.Buffer
+	_, err = writeOpenMetrics(context.Background(), q, &buf, mint, mint)
+	require.NoError(t, err)
+
+	expected := `# TYPE uploads gauge
+uploads 1.7e+12 1700000000.000
+# TYPE uploads_total unknown
+uploads_total 1.7e+12 1700000000.000
+# EOF
+`
+	require.Equal(t, expected, buf.String())
+}
+
+func TestFormatOpenMetrics(t *testing.T) {
+	lset := labels.FromStrings(labels.MetricName, "up", "path", `C:\dir`, "msg", "a \"quoted\"\nline")
+	require.Equal(t, `up{msg="a \"quoted\"\nline",path="C:\\dir"}`, formatOpenMetricsSeries(lset))
+	require.Equal(t, "up", formatOpenMetricsSeries(labels.FromStrings(labels.MetricName, "up")))
+
+	require.Equal(t, "NaN", formatOpenMetricsValue(math.NaN()))
+	require.Equal(t, "+Inf", formatOpenMetricsValue(math.Inf(1)))
+	require.Equal(t, "-Inf", formatOpenMetricsValue(math.Inf(-1)))
+	require.Equal(t, "0.5", formatOpenMetricsValue(0.5))
+	require.Equal(t, "1.5e+09", formatOpenMetricsValue(1.5e9))
+
+	require.Equal(t, "1700000000.123", formatOpenMetricsTimestamp(1700000000123))
+}
+
+func TestOpenMetricsFamily(t *testing.T) {
+	for _, tc := range []struct {
+		lset   labels.Labels
+		family string
+		typ    string
+	}{
+		{labels.FromStrings(labels.MetricName, "go_goroutines"), "go_goroutines", openMetricsTypeGauge},
+		{labels.FromStrings(labels.MetricName, "http_requests_total"), "http_requests", openMetricsTypeCounter},
+		{labels.FromStrings(labels.MetricName, "_total"), "_total", openMetricsTypeGauge},
+		{labels.FromStrings(labels.MetricName, "api_time_bucket", "le", "0.5"), "api_time_bucket", openMetricsTypeUnknown},
+		{labels.FromStrings(labels.MetricName, "api_time_count"), "api_time_count", openMetricsTypeUnknown},
+		{labels.FromStrings(labels.MetricName, "gc_duration_seconds", "quantile", "0.5"), "gc_duration_seconds", openMetricsTypeUnknown},
+	} {
+		family, typ := openMetricsFamily(tc.lset)
+		require.Equal(t, tc.family, family, tc.lset.String())
+		require.Equal(t, tc.typ, typ, tc.lset.String())
+	}
+}
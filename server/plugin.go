@@ -8,6 +8,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"os"
 	"sync"
 	"time"
 
@@ -35,6 +36,9 @@ type Plugin struct {
 
 	client *pluginapi.Client
 
+	// botID is the user ID of the bot posting the notifications.
+	botID string
+
 	// configurationLock synchronizes access to the configuration.
 	configurationLock sync.RWMutex
 
@@ -52,6 +56,10 @@ type Plugin struct {
 	// the local tsdb to be used for head block
 	db *tsdb.DB
 
+	// scrapeManager scrapes the targets into the local tsdb, it's nil if this node
+	// is not collecting the metrics.
+	scrapeManager *scrape.Manager
+
 	// filestore is being used long storage of the immutable blocks
 	fileBackend filestore.FileBackend
 
@@ -63,6 +71,33 @@ type Plugin struct {
 	handler *handler
 
 	scheduler *cluster.JobOnceScheduler
+
+	// runningJobs are the cancel functions of the jobs running on this node.
+	runningJobs     map[string]context.CancelCauseFunc
+	runningJobsLock sync.Mutex
+
+	// remoteBlockSizes caches the sizes of the blocks in the file store by their paths, the
+	// blocks are immutable once uploaded.
+	remoteBlockSizes     map[string]int64
+	remoteBlockSizesLock sync.Mutex
+
+	// dumpScheduleJob periodically creates the dump jobs of the dump schedules
+	dumpScheduleJob *cluster.Job
+
+	// staleJobsJob periodically marks the stale in progress jobs as failed
+	staleJobsJob *cluster.Job
+
+	// jobIndexJob periodically repairs the job index entries left stale
+	jobIndexJob *cluster.Job
+
+	// dumpRetentionJob periodically deletes the dumps exceeding the retention policy
+	dumpRetentionJob *cluster.Job
+
+	// downsampleJob periodically downsamples the old blocks in the file store
+	downsampleJob *cluster.Job
+
+	// remoteCompactionJob periodically merges the adjacent blocks in the file store
+	remoteCompactionJob *cluster.Job
 }
 
 func (p *Plugin) OnActivate() error {
@@ -75,6 +110,14 @@ func (p *Plugin) OnActivate() error {
 
 	p.handler = newHandler(p)
 
+	if err := p.registerCommand(); err != nil {
+		return err
+	}
+
+	if err := p.ensureBot(); err != nil {
+		return err
+	}
+
 	fileSettings := &p.API.GetUnsanitizedConfig().FileSettings
 	fileSettings.SetDefaults(false) // some fields are nil, we should set those to default
 
@@ -96,8 +139,43 @@ func (p *Plugin) OnActivate() error {
 
 	p.scheduler = cluster.GetJobOnceScheduler(p.API)
 	p.scheduler.SetCallback(p.JobCallback)
+
+	// the jobs should be migrated before the scheduler runs them
+	if err = p.migrateJobStore(context.Background()); err != nil {
+		p.API.LogError("could not migrate the jobs", "err", err)
+	}
 	p.scheduler.Start()
 
+	p.dumpScheduleJob, err = cluster.Schedule(p.API, scheduleJobKey, cluster.MakeWaitForInterval(time.Minute), p.runSchedules)
+	if err != nil {
+		return fmt.Errorf("could not schedule dump schedule runner: %w", err)
+	}
+
+	p.staleJobsJob, err = cluster.Schedule(p.API, staleJobsKey, cluster.MakeWaitForInterval(staleJobsInterval), p.failStaleJobs)
+	if err != nil {
+		return fmt.Errorf("could not schedule stale jobs checker: %w", err)
+	}
+
+	p.jobIndexJob, err = cluster.Schedule(p.API, jobIndexReconcileKey, cluster.MakeWaitForInterval(jobIndexReconcileInterval), p.reconcileJobIndex)
+	if err != nil {
+		return fmt.Errorf("could not schedule job index reconciler: %w", err)
+	}
+
+	p.dumpRetentionJob, err = cluster.Schedule(p.API, dumpRetentionKey, cluster.MakeWaitForInterval(dumpRetentionInterval), p.applyDumpRetention)
+	if err != nil {
+		return fmt.Errorf("could not schedule dump retention runner: %w", err)
+	}
+
+	p.downsampleJob, err = cluster.Schedule(p.API, downsampleKey, cluster.MakeWaitForInterval(downsampleInterval), p.downsampleRemote)
+	if err != nil {
+		return fmt.Errorf("could not schedule downsampling runner: %w", err)
+	}
+
+	p.remoteCompactionJob, err = cluster.Schedule(p.API, remoteCompactionKey, cluster.MakeWaitForInterval(remoteCompactionInterval), p.compactRemote)
+	if err != nil {
+		return fmt.Errorf("could not schedule remote compaction runner: %w", err)
+	}
+
 	// we are using a mutually exclusive lock to run a single instance of this plugin
 	// we don't really need to collect metrics twice: although TSDB will take care
 	// of overlapped blocks, it will increase the disk writes to the remote or local
@@ -122,6 +200,21 @@ func (p *Plugin) OnActivate() error {
 		p.singletonLockAcquired = true
 	}
 
+	// from this point on, this node is the one collecting the metrics. We advertise
+	// it so that the other nodes can report which node is the leader.
+	hostname, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("could not get hostname: %w", err)
+	}
+	leaderStatus := &LeaderStatus{
+		Hostname:    hostname,
+		LeaderSince: mmModel.GetMillis(),
+		Topology:    []ClusterNode{},
+	}
+	if err = p.setLeaderStatus(leaderStatus); err != nil {
+		p.API.LogWarn("Could not store the leader status", "error", err.Error())
+	}
+
 	// The metrics plugin is dependent on the metrics endpoint being expoesed, so we need to ensure it is enabled.
 	if cfg := p.API.GetUnsanitizedConfig(); cfg.MetricsSettings.Enable == nil || !*cfg.MetricsSettings.Enable {
 		if lic := p.API.GetLicense(); lic != nil && *lic.Features.Metrics {
@@ -140,7 +233,8 @@ func (p *Plugin) OnActivate() error {
 	p.tsdbLock.Lock()
 	defer p.tsdbLock.Unlock()
 	p.db, err = tsdb.Open(*p.configuration.DBPath, p.logger, nil, &tsdb.Options{
-		RetentionDuration:              int64(localRetentionDays / time.Millisecond),
+		RetentionDuration:              int64(time.Duration(*p.configuration.LocalRetentionDays) * 24 * time.Hour / time.Millisecond),
+		MaxBytes:                       int64(*p.configuration.LocalRetentionMaxSizeMB) * 1024 * 1024,
 		AllowOverlappingCompaction:     *p.configuration.AllowOverlappingCompaction,
 		EnableMemorySnapshotOnShutdown: *p.configuration.EnableMemorySnapshotOnShutdown,
 	}, nil)
@@ -149,6 +243,7 @@ func (p *Plugin) OnActivate() error {
 	}
 
 	manager := scrape.NewManager(nil, p.logger, p.db)
+	p.scrapeManager = manager
 	syncCh := make(chan map[string][]*targetgroup.Group)
 
 	// we start the manager first, then apply the scrape config
@@ -180,6 +275,12 @@ func (p *Plugin) OnActivate() error {
 	}
 	manager.ApplyConfig(scpCfg)
 
+	p.waitGroup.Add(1)
+	go func() {
+		defer p.waitGroup.Done()
+		p.monitorTargets(manager)
+	}()
+
 	// In HA, we want to continuously check for changes to the cluster (e.g. nodes joining/leaving).
 	// In not-HA, we still want to regenerate targets in case plugins
 	// providing metrics (e.g. Calls) started after we did.
@@ -207,6 +308,7 @@ func (p *Plugin) OnActivate() error {
 		for {
 			select {
 			case <-ticker.C:
+				changed := true
 				if p.isHA() {
 					list, err := pingClusterDiscoveryTable(db, *p.API.GetConfig().ClusterSettings.ClusterName)
 					if err != nil {
@@ -214,18 +316,25 @@ func (p *Plugin) OnActivate() error {
 						return
 					}
 
-					if !topologyChanged(currentList, list) {
-						continue
-					}
+					changed = topologyChanged(currentList, list)
 					currentList = list
 				}
 
-				sync, err := p.generateTargetGroup(p.API.GetConfig(), currentList)
-				if err != nil {
-					p.API.LogError("Could not genarate target group for cluster", "error", err.Error())
-					return
+				if changed {
+					sync, err := p.generateTargetGroup(p.API.GetConfig(), currentList)
+					if err != nil {
+						p.API.LogError("Could not genarate target group for cluster", "error", err.Error())
+						return
+					}
+					syncCh <- sync
+					leaderStatus.Topology = newClusterNodes(currentList)
+				}
+
+				// the status is refreshed on every sync, it expires once this node stops collecting.
+				leaderStatus.LastTargetSyncAt = mmModel.GetMillis()
+				if err := p.setLeaderStatus(leaderStatus); err != nil {
+					p.API.LogWarn("Could not store the leader status", "error", err.Error())
 				}
-				syncCh <- sync
 			case <-p.closeChan:
 				p.API.LogDebug("Cluster ping process stopped")
 				return
@@ -258,13 +367,61 @@ func (p *Plugin) OnDeactivate() error {
 		defer p.singletonLock.Unlock()
 	}
 
-	p.tsdbLock.Lock()
-	defer p.tsdbLock.Unlock()
+	if p.dumpScheduleJob != nil {
+		if err := p.dumpScheduleJob.Close(); err != nil {
+			p.API.LogWarn("Could not stop the dump schedule runner", "error", err.Error())
+		}
+	}
+
+	if p.staleJobsJob != nil {
+		if err := p.staleJobsJob.Close(); err != nil {
+			p.API.LogWarn("Could not stop the stale jobs checker", "error", err.Error())
+		}
+	}
+
+	if p.jobIndexJob != nil {
+		if err := p.jobIndexJob.Close(); err != nil {
+			p.API.LogWarn("Could not stop the job index reconciler", "error", err.Error())
+		}
+	}
+
+	if p.dumpRetentionJob != nil {
+		if err := p.dumpRetentionJob.Close(); err != nil {
+			p.API.LogWarn("Could not stop the dump retention runner", "error", err.Error())
+		}
+	}
+
+	if p.downsampleJob != nil {
+		if err := p.downsampleJob.Close(); err != nil {
+			p.API.LogWarn("Could not stop the downsampling runner", "error", err.Error())
+		}
+	}
+
+	if p.remoteCompactionJob != nil {
+		if err := p.remoteCompactionJob.Close(); err != nil {
+			p.API.LogWarn("Could not stop the remote compaction runner", "error", err.Error())
+		}
+	}
 
+	// the background uploads of the local data take the tsdb lock, hence they are waited for
+	// without holding it.
+	p.tsdbLock.Lock()
 	close(p.closeChan)
+	p.tsdbLock.Unlock()
 	p.waitGroup.Wait()
 
+	p.tsdbLock.Lock()
+	defer p.tsdbLock.Unlock()
+
+	// the cluster ping process is stopped, it can't write the leader status anymore
+	if !p.isHA() || p.singletonLockAcquired {
+		if appErr := p.API.KVDelete(KVStoreLeaderKey); appErr != nil {
+			p.API.LogWarn("Could not remove the leader status", "error", appErr.Error())
+		}
+	}
+
 	p.API.LogInfo("Scrape manager stopped")
+	p.scrapeManager = nil
 
 	if p.db != nil {
 		return p.db.Close()
@@ -277,3 +434,28 @@ func (p *Plugin) OnDeactivate() error {
 func (p *Plugin) ServeHTTP(_ *plugin.Context, w http.ResponseWriter, r *http.Request) {
 	p.handler.ServeHTTP(w, r)
 }
+
+// isClosing tells whether the plugin is being deactivated.
+func (p *Plugin) isClosing() bool {
+	select {
+	case <-p.closeChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// closeContext returns a context which is canceled once closeChan is closed, the returned
+// function should be called to release the context.
+func closeContext(closeChan <-chan bool) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-closeChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
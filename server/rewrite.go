@@ -0,0 +1,195 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+// rewriteCommitSize is the number of samples appended before committing the appender
+// to keep the memory usage of the appender bounded.
+const rewriteCommitSize = 5000
+
+// rewriteOptions defines which series and samples are copied by rewriteTSDB.
+type rewriteOptions struct {
+	// matcherSets selects the series to be copied, a series is copied if it matches
+	// any of the sets. All series are copied if there is no matcher set.
+	matcherSets [][]*labels.Matcher
+	// mint and maxt are the inclusive bounds of the samples to be copied.
+	mint int64
+	maxt int64
+	// blockDuration is the duration of the blocks to be written in milliseconds.
+	blockDuration int64
+	// relabel, if set, is applied to the labels of each series before it's written.
+	relabel func(labels.Labels) labels.Labels
+}
+
+// parseMatcherSets parses the series selectors, e.g. `{job="calls"}` or `{__name__=~"go_.*"}`.
+func parseMatcherSets(selectors []string) ([][]*labels.Matcher, error) {
+	matcherSets := make([][]*labels.Matcher, 0, len(selectors))
+	for _, s := range selectors {
+		matchers, err := parser.ParseMetricSelector(s)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse selector %q: %w", s, err)
+		}
+		matcherSets = append(matcherSets, matchers)
+	}
+
+	return matcherSets, nil
+}
+
+// selectSeries returns the sorted series matching any of the matcher sets, all series are
+// returned if there is no matcher set.
+func selectSeries(ctx context.Context, q storage.Querier, matcherSets [][]*labels.Matcher, mint, maxt int64) storage.SeriesSet {
+	if len(matcherSets) == 0 {
+		matcherSets = [][]*labels.Matcher{{labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".+")}}
+	}
+
+	hints := &storage.SelectHints{Start: mint, End: maxt}
+	sets := make([]storage.SeriesSet, 0, len(matcherSets))
+	for _, matchers := range matcherSets {
+		sets = append(sets, q.Select(ctx, true, hints, matchers...))
+	}
+
+	return storage.NewMergeSeriesSet(sets, storage.ChainedSeriesMerge)
+}
+
+// rewriteTSDB copies the selected samples from the querier into new blocks under dst. The
+// data is processed in windows of blockDuration so that only a single block is kept in
+// memory at once.
+func rewriteTSDB(ctx context.Context, logger log.Logger, q storage.Querier, dst string, opts rewriteOptions) error {
+	return forEachWindow(opts, func(window rewriteOptions) error {
+		return rewriteWindow(ctx, logger, q, dst, window)
+	})
+}
+
+// forEachWindow calls fn in order for each window of the block duration within [opts.mint, opts.maxt].
+// The windows are aligned with the block duration as the tsdb does for its own blocks.
+func forEachWindow(opts rewriteOptions, fn func(window rewriteOptions) error) error {
+	for t := opts.mint - opts.mint%opts.blockDuration; t <= opts.maxt; t += opts.blockDuration {
+		window := opts
+		window.mint = max(t, opts.mint)
+		window.maxt = min(t+opts.blockDuration-1, opts.maxt)
+
+		if err := fn(window); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rewriteWindow copies the selected samples within [opts.mint, opts.maxt] into a single block.
+func rewriteWindow(ctx context.Context, logger log.Logger, q storage.Querier, dst string, opts rewriteOptions) (err error) {
+	mint, maxt := opts.mint, opts.maxt
+
+	w, err := tsdb.NewBlockWriter(logger, dst, opts.blockDuration)
+	if err != nil {
+		return fmt.Errorf("could not create block writer: %w", err)
+	}
+	defer func() {
+		if cErr := w.Close(); cErr != nil && err == nil {
+			err = cErr
+		}
+	}()
+
+	set := selectSeries(ctx, q, opts.matcherSets, mint, maxt)
+
+	app := w.Appender(ctx)
+	samples := 0
+	var it chunkenc.Iterator
+	for set.Next() {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		series := set.At()
+		lset := series.Labels()
+		if opts.relabel != nil {
+			lset = opts.relabel(lset)
+		}
+
+		it = series.Iterator(it)
+		for vt := it.Next(); vt != chunkenc.ValNone; vt = it.Next() {
+			var t int64
+			switch vt {
+			case chunkenc.ValFloat:
+				var v float64
+				t, v = it.At()
+				if t < mint || t > maxt {
+					continue
+				}
+				_, err = app.Append(0, lset, t, v)
+			case chunkenc.ValHistogram:
+				var h *histogram.Histogram
+				t, h = it.AtHistogram()
+				if t < mint || t > maxt {
+					continue
+				}
+				_, err = app.AppendHistogram(0, lset, t, h, nil)
+			case chunkenc.ValFloatHistogram:
+				var fh *histogram.FloatHistogram
+				t, fh = it.AtFloatHistogram()
+				if t < mint || t > maxt {
+					continue
+				}
+				_, err = app.AppendHistogram(0, lset, t, nil, fh)
+			}
+			if opts.relabel != nil && isConflictingSampleErr(err) {
+				// relabeling maps different series to the same labels only if their anonymized
+				// values collide, in that case the samples of the later series conflicting with
+				// the already written ones are dropped.
+				err = nil
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("could not append sample: %w", err)
+			}
+
+			samples++
+			if samples%rewriteCommitSize == 0 {
+				if err = app.Commit(); err != nil {
+					return fmt.Errorf("could not commit samples: %w", err)
+				}
+				app = w.Appender(ctx)
+			}
+		}
+		if it.Err() != nil {
+			return fmt.Errorf("could not iterate samples: %w", it.Err())
+		}
+	}
+	if set.Err() != nil {
+		return fmt.Errorf("could not select series: %w", set.Err())
+	}
+
+	if err = app.Commit(); err != nil {
+		return fmt.Errorf("could not commit samples: %w", err)
+	}
+
+	if samples == 0 {
+		return nil
+	}
+
+	if _, err = w.Flush(ctx); err != nil && !errors.Is(err, tsdb.ErrNoSeriesAppended) {
+		return fmt.Errorf("could not flush block: %w", err)
+	}
+
+	return nil
+}
+
+func isConflictingSampleErr(err error) bool {
+	return errors.Is(err, storage.ErrOutOfOrderSample) ||
+		errors.Is(err, storage.ErrDuplicateSampleForTimestamp) ||
+		errors.Is(err, storage.ErrTooOldSample)
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/stretchr/testify/require"
+)
+
+// createTestTSDB writes a sample per minute for each of the series within [mint, maxt].
+func createTestTSDB(t *testing.T, mint, maxt int64, series ...labels.Labels) *tsdb.DB {
+	t.Helper()
+
+	db, err := tsdb.Open(t.TempDir(), log.NewNopLogger(), nil, tsdb.DefaultOptions(), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	app := db.Appender(context.Background())
+	for ts := mint; ts <= maxt; ts += time.Minute.Milliseconds() {
+		for _, lset := range series {
+			_, err = app.Append(0, lset, ts, float64(ts))
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, app.Commit())
+
+	return db
+}
+
+// readTestSeries returns the samples of all series in the tsdb under dir keyed by the series labels.
+func readTestSeries(t *testing.T, dir string) map[string][]int64 {
+	t.Helper()
+
+	db, err := tsdb.Open(dir, log.NewNopLogger(), nil, tsdb.DefaultOptions(), nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	q, err := db.Querier(0, time.Now().Add(time.Hour).UnixMilli())
+	require.NoError(t, err)
+	defer q.Close()
+
+	result := make(map[string][]int64)
+	set := q.Select(context.Background(), true, nil, labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".+"))
+	for set.Next() {
+		series := set.At()
+		it := series.Iterator(nil)
+		for it.Next() != chunkenc.ValNone {
+			ts, _ := it.At()
+			result[series.Labels().String()] = append(result[series.Labels().String()], ts)
+		}
+	}
+	require.NoError(t, set.Err())
+
+	return result
+}
+
+func TestRewriteTSDB(t *testing.T) {
+	maxt := time.Now().Truncate(time.Minute).UnixMilli()
+	mint := maxt - 6*time.Hour.Milliseconds()
+
+	calls := labels.FromStrings(labels.MetricName, "rtc_sessions", "job", "calls")
+	app := labels.FromStrings(labels.MetricName, "go_goroutines", "job", "prometheus")
+	db := createTestTSDB(t, mint, maxt, calls, app)
+
+	q, err := db.Querier(mint, maxt)
+	require.NoError(t, err)
+	defer q.Close()
+
+	t.Run("copy matching series", func(t *testing.T) {
+		matcherSets, err := parseMatcherSets([]string{`{job="calls"}`})
+		require.NoError(t, err)
+
+		dst := t.TempDir()
+		err = rewriteTSDB(context.Background(), log.NewNopLogger(), q, dst, rewriteOptions{
+			matcherSets:   matcherSets,
+			mint:          mint,
+			maxt:          maxt,
+			blockDuration: tsdb.DefaultBlockDuration,
+		})
+		require.NoError(t, err)
+
+		series := readTestSeries(t, dst)
+		require.Len(t, series, 1)
+		require.Len(t, series[calls.String()], 6*60+1)
+	})
+
+	t.Run("copy all series within the time range", func(t *testing.T) {
+		from := mint + 30*time.Minute.Milliseconds()
+		to := maxt - 30*time.Minute.Milliseconds()
+
+		dst := t.TempDir()
+		err = rewriteTSDB(context.Background(), log.NewNopLogger(), q, dst, rewriteOptions{
+			mint:          from,
+			maxt:          to,
+			blockDuration: tsdb.DefaultBlockDuration,
+		})
+		require.NoError(t, err)
+
+		series := readTestSeries(t, dst)
+		require.Len(t, series, 2)
+		for _, samples := range series {
+			require.Len(t, samples, 5*60+1)
+			require.Equal(t, from, samples[0])
+			require.Equal(t, to, samples[len(samples)-1])
+		}
+	})
+
+	t.Run("invalid selector", func(t *testing.T) {
+		_, err := parseMatcherSets([]string{`{job="calls"`})
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,279 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	root "github.com/mattermost/mattermost-plugin-metrics"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
+)
+
+const (
+	ScheduleLockKey    = PluginName + "_schedule_lock"
+	KVStoreScheduleKey = PluginName + "_dump_schedules"
+	scheduleJobKey     = PluginName + "_dump_schedule_runner"
+)
+
+// DumpSchedule creates a dump job periodically. The schedule fires at Hour:Minute (UTC) on the
+// day it's created and every IntervalHours before and after that, each dump covers the last
+// RangeHours.
+type DumpSchedule struct {
+	ID            string `json:"id"`
+	CreateAt      int64  `json:"create_at"`
+	Hour          int    `json:"hour"`
+	Minute        int    `json:"minute"`
+	IntervalHours int    `json:"interval_hours"`
+	RangeHours    int    `json:"range_hours"`
+	// Keep is the number of dumps to retain for this schedule, 0 means keep all.
+	Keep      int   `json:"keep"`
+	LastRunAt int64 `json:"last_run_at"`
+}
+
+func (s *DumpSchedule) IsValid() error {
+	if s.Hour < 0 || s.Hour > 23 {
+		return errors.New("hour should be between 0 and 23")
+	}
+	if s.Minute < 0 || s.Minute > 59 {
+		return errors.New("minute should be between 0 and 59")
+	}
+	if s.IntervalHours < 1 || s.IntervalHours > 24 {
+		return errors.New("interval should be between 1 and 24 hours")
+	}
+	if s.RangeHours < 1 {
+		return errors.New("range should be at least an hour")
+	}
+	if s.Keep < 0 {
+		return errors.New("number of dumps to keep should not be negative")
+	}
+	return nil
+}
+
+// nextRun returns the first time the schedule fires strictly after the given time.
+func (s *DumpSchedule) nextRun(after time.Time) time.Time {
+	return s.lastRun(after).Add(time.Duration(s.IntervalHours) * time.Hour)
+}
+
+// lastRun returns the last time the schedule fires at or before the given time.
+func (s *DumpSchedule) lastRun(before time.Time) time.Time {
+	created := time.UnixMilli(s.CreateAt).UTC()
+	anchor := time.Date(created.Year(), created.Month(), created.Day(), s.Hour, s.Minute, 0, 0, time.UTC)
+	interval := time.Duration(s.IntervalHours) * time.Hour
+
+	// the runs are anchored to the same time, so that the intervals not dividing a day don't drift
+	elapsed := before.Sub(anchor)
+	n := elapsed / interval
+	if elapsed < 0 && elapsed%interval != 0 {
+		n--
+	}
+
+	return anchor.Add(n * interval)
+}
+
+func (p *Plugin) lockScheduleKVMutex(ctx context.Context) (func(), error) {
+	lock, err := cluster.NewMutex(p.API, root.Manifest.Id+ScheduleLockKey)
+	if err != nil {
+		return nil, fmt.Errorf("could not acquire lock: %w", err)
+	}
+
+	if err = lock.LockWithContext(ctx); err != nil {
+		return nil, fmt.Errorf("could not lock the lock: %w", err)
+	}
+
+	return lock.Unlock, nil
+}
+
+// getSchedules should be called while holding the schedule lock.
+func (p *Plugin) getSchedules() (map[string]*DumpSchedule, error) {
+	b, appErr := p.API.KVGet(KVStoreScheduleKey)
+	if appErr != nil {
+		return nil, fmt.Errorf("could not retrieve schedules: %w", appErr)
+	}
+
+	schedules := make(map[string]*DumpSchedule)
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &schedules); err != nil {
+			return nil, fmt.Errorf("could not unmarshal schedules: %w", err)
+		}
+	}
+
+	return schedules, nil
+}
+
+// setSchedules should be called while holding the schedule lock.
+func (p *Plugin) setSchedules(schedules map[string]*DumpSchedule) error {
+	b, err := json.Marshal(schedules)
+	if err != nil {
+		return fmt.Errorf("could not marshal schedules: %w", err)
+	}
+
+	if appErr := p.API.KVSet(KVStoreScheduleKey, b); appErr != nil {
+		return fmt.Errorf("could not store schedules: %w", appErr)
+	}
+
+	return nil
+}
+
+func (p *Plugin) CreateSchedule(ctx context.Context, schedule *DumpSchedule) (*DumpSchedule, error) {
+	if err := schedule.IsValid(); err != nil {
+		return nil, err
+	}
+
+	unlock, err := p.lockScheduleKVMutex(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	schedules, err := p.getSchedules()
+	if err != nil {
+		return nil, err
+	}
+
+	schedule.ID = model.NewId()
+	schedule.CreateAt = time.Now().UnixMilli()
+	schedule.LastRunAt = schedule.CreateAt
+	schedules[schedule.ID] = schedule
+
+	if err = p.setSchedules(schedules); err != nil {
+		return nil, err
+	}
+
+	return schedule, nil
+}
+
+func (p *Plugin) GetAllSchedules(ctx context.Context) (map[string]*DumpSchedule, error) {
+	unlock, err := p.lockScheduleKVMutex(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return p.getSchedules()
+}
+
+// DeleteSchedule removes the schedule, the dumps created by the schedule are kept.
+func (p *Plugin) DeleteSchedule(ctx context.Context, id string) error {
+	unlock, err := p.lockScheduleKVMutex(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	schedules, err := p.getSchedules()
+	if err != nil {
+		return err
+	}
+
+	delete(schedules, id)
+
+	return p.setSchedules(schedules)
+}
+
+// runSchedules is called periodically by a cluster job, it creates the dump jobs for the
+// schedules that are due and applies the retention rules of each schedule.
+func (p *Plugin) runSchedules() {
+	ctx := context.TODO()
+
+	unlock, err := p.lockScheduleKVMutex(ctx)
+	if err != nil {
+		p.API.LogError("could not lock schedules", "err", err)
+		return
+	}
+	defer unlock()
+
+	schedules, err := p.getSchedules()
+	if err != nil {
+		p.API.LogError("could not get schedules", "err", err)
+		return
+	} else if len(schedules) == 0 {
+		return
+	}
+
+	now := time.Now()
+	changed := false
+	for _, schedule := range schedules {
+		if now.Before(schedule.nextRun(time.UnixMilli(schedule.LastRunAt))) {
+			continue
+		}
+
+		// the runs missed while the plugin was down are skipped, only the latest one is dumped
+		last := schedule.lastRun(now)
+		job, err2 := p.CreateJob(ctx, &DumpJob{
+			MinT:       last.Add(-time.Duration(schedule.RangeHours) * time.Hour).UnixMilli(),
+			MaxT:       last.UnixMilli(),
+			ScheduleID: schedule.ID,
+		})
+		if err2 != nil {
+			p.API.LogError("could not create scheduled dump job", "schedule", schedule.ID, "err", err2)
+			continue
+		}
+		p.API.LogInfo("Scheduled dump job created", "schedule", schedule.ID, "job", job.ID)
+
+		schedule.LastRunAt = now.UnixMilli()
+		changed = true
+	}
+
+	if changed {
+		if err = p.setSchedules(schedules); err != nil {
+			p.API.LogError("could not store schedules", "err", err)
+		}
+	}
+
+	if err = p.applyScheduleRetention(ctx, schedules); err != nil {
+		p.API.LogError("could not apply the retention of the scheduled dumps", "err", err)
+	}
+}
+
+// applyScheduleRetention deletes the oldest successful dumps of the schedules exceeding the
+// number of dumps to keep. The failed runs are limited separately, so that they never replace
+// the successful dumps.
+func (p *Plugin) applyScheduleRetention(ctx context.Context, schedules map[string]*DumpSchedule) error {
+	jobs, err := p.listAllJobs(ctx, JobFilter{
+		Statuses: []string{model.JobStatusSuccess, model.JobStatusError},
+	})
+	if err != nil {
+		return err
+	}
+
+	jobsBySchedule := make(map[string]map[string][]*DumpJob)
+	for _, job := range jobs {
+		if job.ScheduleID == "" {
+			continue
+		}
+		if jobsBySchedule[job.ScheduleID] == nil {
+			jobsBySchedule[job.ScheduleID] = make(map[string][]*DumpJob)
+		}
+		// the jobs are listed newest first
+		jobsBySchedule[job.ScheduleID][job.Status] = append(jobsBySchedule[job.ScheduleID][job.Status], job)
+	}
+
+	for id, byStatus := range jobsBySchedule {
+		schedule, ok := schedules[id]
+		if !ok || schedule.Keep == 0 {
+			continue
+		}
+
+		for _, scheduledJobs := range byStatus {
+			if len(scheduledJobs) <= schedule.Keep {
+				continue
+			}
+
+			for _, job := range scheduledJobs[schedule.Keep:] {
+				p.API.LogDebug("deleting scheduled dump exceeding the retention", "schedule", id, "job", job.ID, "status", job.Status)
+				if err = p.DeleteJob(ctx, job.ID); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
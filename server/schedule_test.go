@@ -0,0 +1,122 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDumpScheduleNextRun(t *testing.T) {
+	t.Run("daily schedule", func(t *testing.T) {
+		s := &DumpSchedule{Hour: 2, IntervalHours: 24, RangeHours: 24}
+
+		after := time.Date(2024, 3, 10, 1, 0, 0, 0, time.UTC)
+		require.Equal(t, time.Date(2024, 3, 10, 2, 0, 0, 0, time.UTC), s.nextRun(after))
+
+		after = time.Date(2024, 3, 10, 2, 0, 0, 0, time.UTC)
+		require.Equal(t, time.Date(2024, 3, 11, 2, 0, 0, 0, time.UTC), s.nextRun(after))
+
+		after = time.Date(2024, 3, 10, 23, 59, 0, 0, time.UTC)
+		require.Equal(t, time.Date(2024, 3, 11, 2, 0, 0, 0, time.UTC), s.nextRun(after))
+	})
+
+	t.Run("every six hours", func(t *testing.T) {
+		s := &DumpSchedule{Hour: 3, Minute: 30, IntervalHours: 6, RangeHours: 6}
+
+		after := time.Date(2024, 3, 10, 1, 0, 0, 0, time.UTC)
+		require.Equal(t, time.Date(2024, 3, 10, 3, 30, 0, 0, time.UTC), s.nextRun(after))
+
+		after = time.Date(2024, 3, 10, 0, 10, 0, 0, time.UTC)
+		require.Equal(t, time.Date(2024, 3, 10, 3, 30, 0, 0, time.UTC), s.nextRun(after))
+
+		after = time.Date(2024, 3, 10, 22, 0, 0, 0, time.UTC)
+		require.Equal(t, time.Date(2024, 3, 11, 3, 30, 0, 0, time.UTC), s.nextRun(after))
+
+		after = time.Date(2024, 3, 10, 10, 0, 0, 0, time.UTC)
+		require.Equal(t, time.Date(2024, 3, 10, 15, 30, 0, 0, time.UTC), s.nextRun(after))
+	})
+
+	t.Run("interval not dividing a day", func(t *testing.T) {
+		s := &DumpSchedule{
+			CreateAt:      time.Date(2024, 3, 10, 1, 0, 0, 0, time.UTC).UnixMilli(),
+			Hour:          3,
+			IntervalHours: 5,
+			RangeHours:    5,
+		}
+
+		after := time.Date(2024, 3, 10, 1, 0, 0, 0, time.UTC)
+		require.Equal(t, time.Date(2024, 3, 10, 3, 0, 0, 0, time.UTC), s.nextRun(after))
+
+		// the runs don't restart at 03:00 on the next day
+		after = time.Date(2024, 3, 10, 23, 0, 0, 0, time.UTC)
+		require.Equal(t, time.Date(2024, 3, 11, 4, 0, 0, 0, time.UTC), s.nextRun(after))
+
+		after = time.Date(2024, 3, 11, 4, 0, 0, 0, time.UTC)
+		require.Equal(t, time.Date(2024, 3, 11, 9, 0, 0, 0, time.UTC), s.nextRun(after))
+	})
+}
+
+func TestDumpScheduleLastRun(t *testing.T) {
+	s := &DumpSchedule{
+		CreateAt:      time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC).UnixMilli(),
+		Hour:          3,
+		IntervalHours: 5,
+		RangeHours:    5,
+	}
+
+	before := time.Date(2024, 3, 10, 1, 0, 0, 0, time.UTC)
+	require.Equal(t, time.Date(2024, 3, 9, 22, 0, 0, 0, time.UTC), s.lastRun(before))
+
+	before = time.Date(2024, 3, 10, 3, 0, 0, 0, time.UTC)
+	require.Equal(t, before, s.lastRun(before))
+
+	// the latest run after a downtime of several runs
+	before = time.Date(2024, 3, 12, 9, 59, 0, 0, time.UTC)
+	require.Equal(t, time.Date(2024, 3, 12, 5, 0, 0, 0, time.UTC), s.lastRun(before))
+}
+
+func TestApplyScheduleRetention(t *testing.T) {
+	api := newKVStoreMock()
+	api.On("LogDebug", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Maybe()
+
+	plugin := &Plugin{}
+	plugin.SetAPI(api)
+
+	statuses := []string{model.JobStatusSuccess, model.JobStatusSuccess, model.JobStatusError, model.JobStatusError}
+	ids := make([]string, len(statuses))
+	for i, status := range statuses {
+		ids[i] = model.NewId()
+		require.NoError(t, plugin.saveJob(&DumpJob{
+			ID:         ids[i],
+			Status:     status,
+			CreateAt:   int64(i + 1),
+			ScheduleID: "schedule",
+		}, false))
+	}
+
+	schedules := map[string]*DumpSchedule{"schedule": {ID: "schedule", Keep: 1}}
+	require.NoError(t, plugin.applyScheduleRetention(context.Background(), schedules))
+
+	list, err := plugin.ListJobs(context.Background(), JobFilter{})
+	require.NoError(t, err)
+	require.Len(t, list.Jobs, 2)
+	// the failed runs don't replace the last successful dump
+	require.Equal(t, ids[3], list.Jobs[0].ID)
+	require.Equal(t, ids[1], list.Jobs[1].ID)
+}
+
+func TestDumpScheduleIsValid(t *testing.T) {
+	require.NoError(t, (&DumpSchedule{Hour: 2, IntervalHours: 24, RangeHours: 24}).IsValid())
+	require.Error(t, (&DumpSchedule{Hour: 24, IntervalHours: 24, RangeHours: 24}).IsValid())
+	require.Error(t, (&DumpSchedule{Minute: 60, IntervalHours: 24, RangeHours: 24}).IsValid())
+	require.Error(t, (&DumpSchedule{IntervalHours: 0, RangeHours: 24}).IsValid())
+	require.Error(t, (&DumpSchedule{IntervalHours: 24, RangeHours: 0}).IsValid())
+	require.Error(t, (&DumpSchedule{IntervalHours: 24, RangeHours: 24, Keep: -1}).IsValid())
+}
@@ -9,33 +9,30 @@ import (
 	"fmt"
 	"io"
 	"path/filepath"
-	"sort"
 
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin"
 )
 
-func (p *Plugin) GenerateSupportData(_ *plugin.Context) ([]*model.FileData, error) {
-	jobs, err := p.GetAllJobs(context.TODO())
-	if err != nil {
-		return nil, fmt.Errorf("could not retrieve jobs")
-	}
-
-	jobSlice := make([]*DumpJob, 0, len(jobs))
-	for _, job := range jobs {
-		jobSlice = append(jobSlice, job)
-	}
-
-	sort.Slice(jobSlice, func(i, j int) bool {
-		return jobSlice[i].CreateAt > jobSlice[j].CreateAt
-	})
+// supportPacketJobsPerPage is the number of jobs read at once while looking for the newest dump.
+const supportPacketJobsPerPage = 10
 
+func (p *Plugin) GenerateSupportData(_ *plugin.Context) ([]*model.FileData, error) {
+	// only the newest successful dump is included, the jobs are read until it's found
 	var recentJob *DumpJob
-	for _, j := range jobSlice {
-		if j.Status == model.JobStatusSuccess {
+	err := p.forEachJob(context.TODO(), JobFilter{
+		Statuses: []string{model.JobStatusSuccess},
+		PerPage:  supportPacketJobsPerPage,
+	}, func(j *DumpJob) bool {
+		// imported archives are not created by this server, they are not included
+		if j.Type != JobTypeImport {
 			recentJob = j
-			break
+			return false
 		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve jobs")
 	}
 
 	if recentJob == nil {
@@ -9,15 +9,20 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"math"
 	"os"
 	"path/filepath"
+	"sort"
 	"time"
 
 	"github.com/oklog/ulid"
 	"github.com/prometheus/prometheus/tsdb"
-	"golang.org/x/exp/slices"
 )
 
+// incompleteBlockGracePeriod is the duration after which a block directory without meta.json
+// that is not written anymore is considered an interrupted upload.
+const incompleteBlockGracePeriod = 6 * time.Hour
+
 type WriterFunc func(io.Reader, string) (int64, error)
 type ReaderFunc func(string) ([]byte, error)
 
@@ -49,13 +54,18 @@ loop:
 			err := p.syncWithRemote(localStorageDir, remoteStorageDir, *p.configuration.RetentionDurationDays)
 			if err != nil {
 				p.API.LogError("could not sync with remote store", "err", err)
+				p.notify(fmt.Sprintf(":warning: The metrics could not be synced with the file store, the sync is stopped until the plugin is restarted: %s", err))
 				break loop
 			}
 		case <-tickFileStoreCleanUp.C:
 			p.API.LogDebug("Cleaning up the filestore...")
 			err := p.cleanupRemote(remoteStorageDir, *p.configuration.RetentionDurationDays)
+			if err == nil {
+				err = p.limitRemoteSize(remoteStorageDir, int64(*p.configuration.RemoteRetentionMaxSizeMB)*1024*1024)
+			}
 			if err != nil {
 				p.API.LogError("unable cleanup remote store, skipping cleanup", "err", err)
+				p.notify(fmt.Sprintf(":warning: The obsolete metrics could not be cleaned up from the file store: %s", err))
 				continue
 			}
 		case <-p.closeChan:
@@ -133,45 +143,131 @@ func (p *Plugin) syncWithRemote(localStorageDir, remoteStorageDir string, retent
 		blocksInSFileStore[dir] = true
 	}
 
-	// we should skip the blocks those are already in the filestore
-	// since the tsdb blocks are immutable, we don't need to worry whether
-	// if the block is changed over time
-	blocksToSync = slices.DeleteFunc(blocksToSync, func(s string) bool {
-		_, ok := blocksInSFileStore[s]
-		return ok
-	})
-
 	for _, block := range blocksToSync {
-		err2 := copyDirectory(
-			filepath.Join(localStorageDir, block),
-			filepath.Join(remoteStorageDir, block),
-			p.fileBackend.WriteFile,
-		)
-		if err2 != nil {
-			p.API.LogError("could not write block to filestore", "err", err2)
+		localDir := filepath.Join(localStorageDir, block)
+		remoteDir := filepath.Join(remoteStorageDir, block)
+
+		// since the tsdb blocks are immutable, we only need to check whether the blocks
+		// in the filestore are completely uploaded. The incomplete ones are repaired.
+		if blocksInSFileStore[block] {
+			uploaded, err3 := p.isBlockUploaded(localDir, remoteDir)
+			if err3 != nil {
+				p.API.LogWarn("could not check the block in the filestore", "ulid", block, "err", err3)
+			}
+			if uploaded {
+				continue
+			}
+			p.API.LogInfo("Repairing incomplete block in the filestore", "ulid", block)
+
+			// the block is hidden from the readers until it's repaired
+			if err3 = p.fileBackend.RemoveFile(filepath.Join(remoteDir, metaFileName)); err3 != nil {
+				p.API.LogDebug("could not remove the meta file of the incomplete block", "ulid", block, "err", err3)
+			}
+		}
+
+		if err3 := p.uploadBlock(localDir, remoteDir); err3 != nil {
+			p.API.LogError("could not write block to filestore", "ulid", block, "err", err3)
 		}
 	}
 
 	return nil
 }
 
+// blocksExceedingSize returns the oldest blocks exceeding the maximum total size. The blocks
+// should be sorted newest first.
+func blocksExceedingSize(blocks []remoteBlock, maxBytes int64) []remoteBlock {
+	var exceeding []remoteBlock
+	var totalBytes int64
+	for _, b := range blocks {
+		totalBytes += b.size
+		if totalBytes > maxBytes {
+			exceeding = append(exceeding, b)
+		}
+	}
+
+	return exceeding
+}
+
+// remoteBlockSize returns the total size of the files of a block in the remote filestore.
+func (p *Plugin) remoteBlockSize(dir string) (int64, error) {
+	files, err := p.fileBackend.ListDirectoryRecursively(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	for _, f := range files {
+		s, err := p.fileBackend.FileSize(f)
+		if err != nil {
+			return 0, err
+		}
+		size += s
+	}
+
+	return size, nil
+}
+
+// cleanupIncompleteBlock deletes the block directory without meta.json, i.e. an interrupted
+// upload, once it's not written for the grace period. The blocks being uploaded are kept.
+func (p *Plugin) cleanupIncompleteBlock(dir string) {
+	id, err := ulid.Parse(filepath.Base(dir))
+	if err != nil {
+		return
+	}
+
+	// the meta file might not be read due to an error in the file store
+	if ok, err := p.fileBackend.FileExists(filepath.Join(dir, metaFileName)); err != nil || ok {
+		return
+	}
+
+	files, err := p.fileBackend.ListDirectoryRecursively(dir)
+	if err != nil {
+		p.API.LogDebug("unable to list the files of the incomplete block", "ulid", id, "err", err)
+		return
+	}
+
+	var lastModified time.Time
+	for _, f := range files {
+		modTime, err := p.fileBackend.FileModTime(f)
+		if err != nil {
+			p.API.LogDebug("unable to get the modification time of the file", "path", f, "err", err)
+			return
+		}
+		if modTime.After(lastModified) {
+			lastModified = modTime
+		}
+	}
+
+	if time.Since(lastModified) < incompleteBlockGracePeriod {
+		return
+	}
+
+	p.API.LogInfo("Deleting incomplete block from the filestore", "ulid", id)
+	if err = p.fileBackend.RemoveDirectory(dir); err != nil {
+		p.API.LogWarn("unable to remove block from filestore", "err", err)
+	}
+}
+
+// cleanupRemote deletes the blocks older than the retention period from the remote filestore.
 func (p *Plugin) cleanupRemote(remoteStorageDir string, retentionDays int) error {
 	ret := time.Now().AddDate(0, 0, -1*retentionDays)
 
 	// get the blocks if there is any block in the remote filestore
-	blocks, err := p.fileBackend.ListDirectory(remoteStorageDir)
+	dirs, err := p.fileBackend.ListDirectory(remoteStorageDir)
 	if err != nil {
 		return err
 	}
 
 	// read block meta from the remote filestore and decide if they are older than the
 	// retention period. If so, delete.
-	for _, b := range blocks {
+	for _, b := range dirs {
 		meta, err := readBlockMeta(filepath.Join(b, metaFileName), p.fileBackend.ReadFile)
 		if err != nil {
 			// we intentionally log with debug level here, file store returns wrapped errors
 			// and to not pollute the logs, we simply reducing the log level here
 			p.API.LogDebug("unable to read meta file", "err", err)
+
+			p.cleanupIncompleteBlock(b)
 			continue
 		}
 
@@ -184,5 +280,80 @@ func (p *Plugin) cleanupRemote(remoteStorageDir string, retentionDays int) error
 			}
 		}
 	}
+
+	return nil
+}
+
+// limitRemoteSize deletes the oldest blocks until the total size of the raw and the
+// downsampled blocks in the file store is below maxBytes. The blocks merged into another
+// block are not counted, those are deleted once the grace period of the merge ends.
+func (p *Plugin) limitRemoteSize(remoteStorageDir string, maxBytes int64) error {
+	if maxBytes <= 0 {
+		return nil
+	}
+
+	roots := []string{remoteStorageDir}
+	for _, resolution := range downsampleResolutions {
+		roots = append(roots, downsampleDir(remoteStorageDir, resolution))
+	}
+
+	var blocks []remoteBlock
+	for _, root := range roots {
+		rootBlocks, err := p.listRemoteBlocks(root, math.MinInt64, math.MaxInt64)
+		if err != nil {
+			return err
+		}
+		blocks = append(blocks, withoutCompactedBlocks(withoutSourceBlocks(rootBlocks))...)
+	}
+
+	sizes, err := p.cachedBlockSizes(blocks)
+	if err != nil {
+		return err
+	}
+	for i := range blocks {
+		blocks[i].size = sizes[blocks[i].path]
+	}
+
+	// the newest blocks are kept
+	sort.SliceStable(blocks, func(i, j int) bool {
+		return blocks[i].meta.MaxTime > blocks[j].meta.MaxTime
+	})
+
+	for _, b := range blocksExceedingSize(blocks, maxBytes) {
+		p.API.LogInfo("Deleting block exceeding the size limit from the filestore", "ulid", b.meta.ULID, "size", b.size)
+		if err = p.fileBackend.RemoveDirectory(b.path); err != nil {
+			p.API.LogWarn("unable to remove block from filestore", "err", err)
+			continue
+		}
+
+		p.remoteBlockSizesLock.Lock()
+		delete(p.remoteBlockSizes, b.path)
+		p.remoteBlockSizesLock.Unlock()
+	}
+
 	return nil
 }
+
+// cachedBlockSizes returns the sizes of the blocks by their paths. Only the sizes of the blocks
+// not seen before are read from the file store, the sizes of the blocks not listed anymore are
+// dropped from the cache.
+func (p *Plugin) cachedBlockSizes(blocks []remoteBlock) (map[string]int64, error) {
+	p.remoteBlockSizesLock.Lock()
+	defer p.remoteBlockSizesLock.Unlock()
+
+	sizes := make(map[string]int64, len(blocks))
+	for _, b := range blocks {
+		size, ok := p.remoteBlockSizes[b.path]
+		if !ok {
+			var err error
+			size, err = p.remoteBlockSize(b.path)
+			if err != nil {
+				return nil, fmt.Errorf("could not get the size of the block %s: %w", b.meta.ULID, err)
+			}
+		}
+		sizes[b.path] = size
+	}
+	p.remoteBlockSizes = sizes
+
+	return sizes, nil
+}
@@ -4,6 +4,7 @@
 package main
 
 import (
+	"bytes"
 	"crypto/rand"
 	"encoding/json"
 	"os"
@@ -11,9 +12,13 @@ import (
 	"testing"
 	"time"
 
+	"github.com/mattermost/mattermost/server/v8/platform/shared/filestore"
 	"github.com/oklog/ulid"
 	"github.com/prometheus/prometheus/tsdb"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+
+	pluginmocks "github.com/mattermost/mattermost-plugin-metrics/server/mocks/github.com/mattermost/mattermost/server/public/plugin"
 )
 
 func TestReadBlockMeta(t *testing.T) {
@@ -65,3 +70,149 @@ func TestReadBlockMeta(t *testing.T) {
 		require.Error(t, err)
 	})
 }
+
+func TestCleanupRemote(t *testing.T) {
+	fsDir := t.TempDir()
+	fs, err := filestore.NewFileBackend(filestore.FileBackendSettings{
+		DriverName: "local",
+		Directory:  fsDir,
+	})
+	require.NoError(t, err)
+
+	api := &pluginmocks.MockAPI{}
+	api.On("LogInfo", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Maybe()
+	api.On("LogInfo", mock.Anything, mock.Anything, mock.Anything).Maybe()
+	api.On("LogDebug", mock.Anything, mock.Anything, mock.Anything).Maybe()
+
+	plugin := &Plugin{
+		fileBackend: fs,
+	}
+	plugin.SetAPI(api)
+
+	remoteStorageDir := filepath.Join(pluginDataDir, PluginName, tsdbDirName)
+
+	// writes a block ending days ago with an index file of the given size under root
+	writeBlockAt := func(root string, days int, size int, sources ...ulid.ULID) string {
+		id := ulid.MustNew(ulid.Now(), rand.Reader)
+		maxt := time.Now().AddDate(0, 0, -days)
+		data, mErr := json.Marshal(&tsdb.BlockMeta{
+			ULID:       id,
+			MinTime:    maxt.Add(-2 * time.Hour).UnixMilli(),
+			MaxTime:    maxt.UnixMilli(),
+			Version:    metaVersion1,
+			Compaction: tsdb.BlockMetaCompaction{Sources: sources},
+		})
+		require.NoError(t, mErr)
+
+		dir := filepath.Join(root, id.String())
+		_, wErr := fs.WriteFile(bytes.NewReader(data), filepath.Join(dir, metaFileName))
+		require.NoError(t, wErr)
+		_, wErr = fs.WriteFile(bytes.NewReader(make([]byte, size)), filepath.Join(dir, "index"))
+		require.NoError(t, wErr)
+
+		return dir
+	}
+	writeBlock := func(days int, size int) string {
+		return writeBlockAt(remoteStorageDir, days, size)
+	}
+
+	remainingAt := func(root string) []string {
+		dirs, lErr := fs.ListDirectory(root)
+		require.NoError(t, lErr)
+		return dirs
+	}
+	remaining := func() []string {
+		return remainingAt(remoteStorageDir)
+	}
+
+	t.Run("retention period", func(t *testing.T) {
+		b1 := writeBlock(1, 100)
+		b2 := writeBlock(10, 100)
+		writeBlock(20, 100)
+		defer func() {
+			require.NoError(t, fs.RemoveDirectory(remoteStorageDir))
+		}()
+
+		require.NoError(t, plugin.cleanupRemote(remoteStorageDir, 15))
+		require.ElementsMatch(t, []string{b1, b2}, remaining())
+	})
+
+	t.Run("incomplete blocks", func(t *testing.T) {
+		b1 := writeBlock(1, 100)
+		defer func() {
+			require.NoError(t, fs.RemoveDirectory(remoteStorageDir))
+		}()
+
+		writeIncomplete := func(modTime time.Time) string {
+			dir := filepath.Join(remoteStorageDir, ulid.MustNew(ulid.Now(), rand.Reader).String())
+			_, wErr := fs.WriteFile(bytes.NewReader([]byte("index")), filepath.Join(dir, "index"))
+			require.NoError(t, wErr)
+			require.NoError(t, os.Chtimes(filepath.Join(fsDir, dir, "index"), modTime, modTime))
+			return dir
+		}
+
+		// the block being uploaded is kept, the interrupted upload is deleted after the
+		// grace period regardless of the retention
+		uploading := writeIncomplete(time.Now())
+		writeIncomplete(time.Now().Add(-incompleteBlockGracePeriod - time.Minute))
+
+		require.NoError(t, plugin.cleanupRemote(remoteStorageDir, 15))
+		require.ElementsMatch(t, []string{b1, uploading}, remaining())
+	})
+
+	t.Run("maximum size", func(t *testing.T) {
+		b1 := writeBlock(1, 1000)
+		b2 := writeBlock(2, 1000)
+		writeBlock(3, 1000)
+		writeBlock(20, 1000)
+		defer func() {
+			require.NoError(t, fs.RemoveDirectory(remoteStorageDir))
+		}()
+
+		// the meta files are counted as well, hence two blocks fit in 2500 bytes
+		require.NoError(t, plugin.cleanupRemote(remoteStorageDir, 15))
+		require.NoError(t, plugin.limitRemoteSize(remoteStorageDir, 2500))
+		require.ElementsMatch(t, []string{b1, b2}, remaining())
+	})
+
+	t.Run("maximum size with merged blocks", func(t *testing.T) {
+		s1 := writeBlock(2, 1000)
+		s2 := writeBlock(2, 1000)
+		merged := writeBlockAt(remoteStorageDir, 1, 2000, ulid.MustParse(filepath.Base(s1)), ulid.MustParse(filepath.Base(s2)))
+		defer func() {
+			require.NoError(t, fs.RemoveDirectory(remoteStorageDir))
+		}()
+
+		// the source blocks kept for the grace period of the merge are not counted
+		require.NoError(t, plugin.limitRemoteSize(remoteStorageDir, 2500))
+		require.ElementsMatch(t, []string{s1, s2, merged}, remaining())
+	})
+
+	t.Run("maximum size with downsampled blocks", func(t *testing.T) {
+		b1 := writeBlock(1, 1000)
+		b2 := writeBlockAt(downsampleDir(remoteStorageDir, resolution5m), 2, 1000)
+		b3 := writeBlockAt(downsampleDir(remoteStorageDir, resolution1h), 3, 1000)
+		writeBlockAt(downsampleDir(remoteStorageDir, resolution1h), 30, 1000)
+		defer func() {
+			require.NoError(t, fs.RemoveDirectory(remoteStorageDir))
+			for _, resolution := range downsampleResolutions {
+				require.NoError(t, fs.RemoveDirectory(downsampleDir(remoteStorageDir, resolution)))
+			}
+		}()
+
+		// the oldest block is deleted regardless of its resolution
+		require.NoError(t, plugin.limitRemoteSize(remoteStorageDir, 3800))
+		require.Equal(t, []string{b1}, remaining())
+		require.Equal(t, []string{b2}, remainingAt(downsampleDir(remoteStorageDir, resolution5m)))
+		require.Equal(t, []string{b3}, remainingAt(downsampleDir(remoteStorageDir, resolution1h)))
+
+		// the sizes of the remaining blocks are cached, the deleted ones are dropped
+		require.Len(t, plugin.remoteBlockSizes, 3)
+		require.Contains(t, plugin.remoteBlockSizes, b3)
+
+		// a cached size is used instead of reading the block again
+		plugin.remoteBlockSizes[b1] = 2000
+		require.NoError(t, plugin.limitRemoteSize(remoteStorageDir, 3800))
+		require.NotContains(t, remainingAt(downsampleDir(remoteStorageDir, resolution1h)), b3)
+	})
+}
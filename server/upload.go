@@ -0,0 +1,190 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// uploadBlock uploads the block directory into the file store. Each file is verified once
+// it's written and meta.json is written last as the commit marker, the blocks without meta.json
+// are ignored by the readers. The files already in the file store are kept if they match the
+// local ones, hence an interrupted upload is resumed by calling it again.
+func (p *Plugin) uploadBlock(localDir, remoteDir string) error {
+	files, err := blockFiles(localDir)
+	if err != nil {
+		return fmt.Errorf("could not list the block files: %w", err)
+	}
+
+	for _, f := range files {
+		if err = p.uploadVerifiedFile(filepath.Join(localDir, f), filepath.Join(remoteDir, f)); err != nil {
+			return fmt.Errorf("could not upload %s: %w", f, err)
+		}
+	}
+
+	return nil
+}
+
+// blockFiles returns the paths of the regular files in the block directory relative to it,
+// meta.json is always the last one.
+func blockFiles(dir string) ([]string, error) {
+	var files []string
+	hasMeta := false
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		if rel == metaFileName {
+			hasMeta = true
+			return nil
+		}
+		files = append(files, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !hasMeta {
+		return nil, fmt.Errorf("%s is not found in %s", metaFileName, dir)
+	}
+	sort.Strings(files)
+
+	return append(files, metaFileName), nil
+}
+
+// uploadVerifiedFile writes the local file into the file store unless it's already there. The
+// file is hashed while it's being written, hence the uploaded bytes are verified without reading
+// the file back, the written file is verified by its size only. The file left by an interrupted
+// upload is read back and verified by its checksum before it's kept.
+func (p *Plugin) uploadVerifiedFile(src, dst string) error {
+	size, checksum, err := fileChecksum(src)
+	if err != nil {
+		return err
+	}
+
+	if ok, _ := p.fileBackend.FileExists(dst); ok {
+		if p.verifyRemoteFile(dst, size, checksum) == nil {
+			return nil
+		}
+		p.API.LogDebug("Overwriting the mismatching file in the filestore", "path", dst)
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	written, err := p.fileBackend.WriteFile(io.TeeReader(f, h), dst)
+	if err != nil {
+		return err
+	}
+	if written != size {
+		return fmt.Errorf("written %d bytes instead of %d", written, size)
+	}
+	if !bytes.Equal(h.Sum(nil), checksum) {
+		return errors.New("the file is changed while it's being uploaded")
+	}
+
+	remoteSize, err := p.fileBackend.FileSize(dst)
+	if err != nil {
+		return err
+	}
+	if remoteSize != size {
+		return fmt.Errorf("the size of the file in the filestore is %d instead of %d", remoteSize, size)
+	}
+
+	return nil
+}
+
+// verifyRemoteFile returns an error if the size or the checksum of the file in the file store
+// don't match the given ones.
+func (p *Plugin) verifyRemoteFile(path string, size int64, checksum []byte) error {
+	remoteSize, err := p.fileBackend.FileSize(path)
+	if err != nil {
+		return err
+	}
+	if remoteSize != size {
+		return fmt.Errorf("the size of the file in the filestore is %d instead of %d", remoteSize, size)
+	}
+
+	r, err := p.fileBackend.Reader(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, r); err != nil {
+		return err
+	}
+	if !bytes.Equal(h.Sum(nil), checksum) {
+		return errors.New("the checksum of the file in the filestore does not match")
+	}
+
+	return nil
+}
+
+// isBlockUploaded returns whether the block is completely uploaded to the file store, i.e. the
+// commit marker exists and the sizes of the files match the local ones.
+func (p *Plugin) isBlockUploaded(localDir, remoteDir string) (bool, error) {
+	if ok, err := p.fileBackend.FileExists(filepath.Join(remoteDir, metaFileName)); err != nil || !ok {
+		return false, err
+	}
+
+	files, err := blockFiles(localDir)
+	if err != nil {
+		return false, err
+	}
+
+	for _, f := range files {
+		info, err := os.Stat(filepath.Join(localDir, f))
+		if err != nil {
+			return false, err
+		}
+
+		remoteSize, err := p.fileBackend.FileSize(filepath.Join(remoteDir, f))
+		if err != nil || remoteSize != info.Size() {
+			// the missing files are reported as errors by the file store
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func fileChecksum(path string) (int64, []byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return size, h.Sum(nil), nil
+}
@@ -0,0 +1,205 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/v8/platform/shared/filestore"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	pluginmocks "github.com/mattermost/mattermost-plugin-metrics/server/mocks/github.com/mattermost/mattermost/server/public/plugin"
+)
+
+// truncatingBackend writes only the first half of the file to the path.
+type truncatingBackend struct {
+	filestore.FileBackend
+	path string
+}
+
+func (b *truncatingBackend) WriteFile(fr io.Reader, path string) (int64, error) {
+	if path != b.path {
+		return b.FileBackend.WriteFile(fr, path)
+	}
+
+	data, err := io.ReadAll(fr)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err = b.FileBackend.WriteFile(bytes.NewReader(data[:len(data)/2]), path); err != nil {
+		return 0, err
+	}
+	return 0, errors.New("connection reset")
+}
+
+// readFailingBackend fails reading the files back.
+type readFailingBackend struct {
+	filestore.FileBackend
+}
+
+func (b *readFailingBackend) Reader(string) (filestore.ReadCloseSeeker, error) {
+	return nil, errors.New("the uploaded files should not be read back")
+}
+
+// writeTestBlock writes a block with placeholder files into dir and returns the block directory.
+func writeTestBlock(t *testing.T, dir string) string {
+	t.Helper()
+
+	id := ulid.MustNew(ulid.Now(), rand.Reader)
+	blockDir := filepath.Join(dir, id.String())
+	require.NoError(t, os.MkdirAll(filepath.Join(blockDir, "chunks"), 0750))
+
+	meta, err := json.Marshal(&tsdb.BlockMeta{
+		ULID:    id,
+		MinTime: time.Now().Add(-2 * time.Hour).UnixMilli(),
+		MaxTime: time.Now().UnixMilli(),
+		Version: metaVersion1,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(filepath.Join(blockDir, metaFileName), meta, 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(blockDir, "index"), []byte("index contents"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(blockDir, "tombstones"), []byte("tombstones"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(blockDir, "chunks", "000001"), []byte("chunk contents"), 0600))
+
+	return blockDir
+}
+
+func requireSameFiles(t *testing.T, fs filestore.FileBackend, localDir, remoteDir string) {
+	t.Helper()
+
+	files, err := blockFiles(localDir)
+	require.NoError(t, err)
+	for _, f := range files {
+		expected, err := os.ReadFile(filepath.Join(localDir, f))
+		require.NoError(t, err)
+
+		actual, err := fs.ReadFile(filepath.Join(remoteDir, f))
+		require.NoError(t, err)
+		require.Equal(t, expected, actual, f)
+	}
+}
+
+func TestUploadBlock(t *testing.T) {
+	fs, err := filestore.NewFileBackend(filestore.FileBackendSettings{
+		DriverName: "local",
+		Directory:  t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	api := &pluginmocks.MockAPI{}
+	api.On("LogDebug", mock.Anything, mock.Anything, mock.Anything).Maybe()
+
+	plugin := &Plugin{
+		fileBackend: fs,
+	}
+	plugin.SetAPI(api)
+
+	blockDir := writeTestBlock(t, t.TempDir())
+	remoteDir := filepath.Join(pluginDataDir, PluginName, tsdbDirName, filepath.Base(blockDir))
+
+	files, err := blockFiles(blockDir)
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.Join("chunks", "000001"), "index", "tombstones", metaFileName}, files)
+
+	t.Run("interrupted upload is not committed", func(t *testing.T) {
+		plugin.fileBackend = &truncatingBackend{FileBackend: fs, path: filepath.Join(remoteDir, "index")}
+		defer func() { plugin.fileBackend = fs }()
+
+		require.Error(t, plugin.uploadBlock(blockDir, remoteDir))
+
+		ok, err := fs.FileExists(filepath.Join(remoteDir, metaFileName))
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		uploaded, err := plugin.isBlockUploaded(blockDir, remoteDir)
+		require.NoError(t, err)
+		require.False(t, uploaded)
+	})
+
+	t.Run("upload is resumed", func(t *testing.T) {
+		require.NoError(t, plugin.uploadBlock(blockDir, remoteDir))
+		requireSameFiles(t, fs, blockDir, remoteDir)
+
+		uploaded, err := plugin.isBlockUploaded(blockDir, remoteDir)
+		require.NoError(t, err)
+		require.True(t, uploaded)
+	})
+
+	t.Run("truncated file is uploaded again", func(t *testing.T) {
+		_, err := fs.WriteFile(bytes.NewReader([]byte("chunk")), filepath.Join(remoteDir, "chunks", "000001"))
+		require.NoError(t, err)
+
+		require.NoError(t, plugin.uploadBlock(blockDir, remoteDir))
+		requireSameFiles(t, fs, blockDir, remoteDir)
+	})
+
+	t.Run("corrupted file is uploaded again", func(t *testing.T) {
+		_, err := fs.WriteFile(bytes.NewReader([]byte("chunk_contents")), filepath.Join(remoteDir, "chunks", "000001"))
+		require.NoError(t, err)
+
+		require.NoError(t, plugin.uploadBlock(blockDir, remoteDir))
+		requireSameFiles(t, fs, blockDir, remoteDir)
+	})
+
+	t.Run("uploaded files are not read back", func(t *testing.T) {
+		plugin.fileBackend = &readFailingBackend{FileBackend: fs}
+		defer func() { plugin.fileBackend = fs }()
+
+		other := writeTestBlock(t, t.TempDir())
+		otherRemoteDir := filepath.Join(pluginDataDir, PluginName, tsdbDirName, filepath.Base(other))
+		require.NoError(t, plugin.uploadBlock(other, otherRemoteDir))
+		requireSameFiles(t, fs, other, otherRemoteDir)
+	})
+}
+
+func TestSyncWithRemoteRepairsIncompleteBlocks(t *testing.T) {
+	fs, err := filestore.NewFileBackend(filestore.FileBackendSettings{
+		DriverName: "local",
+		Directory:  t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	api := &pluginmocks.MockAPI{}
+	api.On("LogInfo", mock.Anything, mock.Anything, mock.Anything).Maybe()
+	api.On("LogDebug", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Maybe()
+
+	plugin := &Plugin{
+		fileBackend: fs,
+	}
+	plugin.SetAPI(api)
+
+	localStorageDir := t.TempDir()
+	complete := writeTestBlock(t, localStorageDir)
+	incomplete := writeTestBlock(t, localStorageDir)
+	missing := writeTestBlock(t, localStorageDir)
+
+	remoteStorageDir := filepath.Join(pluginDataDir, PluginName, tsdbDirName)
+	remoteDir := func(blockDir string) string {
+		return filepath.Join(remoteStorageDir, filepath.Base(blockDir))
+	}
+
+	require.NoError(t, plugin.uploadBlock(complete, remoteDir(complete)))
+	// the previous versions uploaded meta.json before the tombstones
+	require.NoError(t, copyFile(filepath.Join(incomplete, "index"), filepath.Join(remoteDir(incomplete), "index"), fs.WriteFile))
+	require.NoError(t, copyFile(filepath.Join(incomplete, metaFileName), filepath.Join(remoteDir(incomplete), metaFileName), fs.WriteFile))
+
+	require.NoError(t, plugin.syncWithRemote(localStorageDir, remoteStorageDir, 1))
+
+	for _, blockDir := range []string{complete, incomplete, missing} {
+		requireSameFiles(t, fs, blockDir, remoteDir(blockDir))
+	}
+}
@@ -5,7 +5,7 @@ import {Client4} from 'mattermost-redux/client';
 
 import {DateRange} from 'react-day-picker';
 
-import {Job, TSDBStats} from '../types/types';
+import {Anonymization, ClusterStatus, DumpDelivery, DumpFormat, DumpSchedule, Job, JobList, JobQuery, ManifestVerification, TSDBStats} from '../types/types';
 import {manifest} from '@/manifest';
 
 export function getTSDBStats() {
@@ -15,18 +15,42 @@ export function getTSDBStats() {
     );
 }
 
-export function getJobs() {
-    return Client4.doFetch<Job[]>(
-        `${Client4.getUrl()}/plugins/${manifest.id}/jobs`,
+export function getClusterStatus() {
+    return Client4.doFetch<ClusterStatus>(
+        `${Client4.getUrl()}/plugins/${manifest.id}/cluster/status`,
         {method: 'get'},
     );
 }
 
-export async function createJob(range: DateRange) {
+export function getJobs(query: JobQuery = {}) {
+    const params = new URLSearchParams();
+    if (query.status?.length) {
+        params.append('status', query.status.join(','));
+    }
+    if (query.created_after) {
+        params.append('created_after', query.created_after.toString());
+    }
+    if (query.created_before) {
+        params.append('created_before', query.created_before.toString());
+    }
+    if (query.page) {
+        params.append('page', query.page.toString());
+    }
+    if (query.per_page) {
+        params.append('per_page', query.per_page.toString());
+    }
+
+    return Client4.doFetch<JobList>(
+        `${Client4.getUrl()}/plugins/${manifest.id}/jobs?${params.toString()}`,
+        {method: 'get'},
+    );
+}
+
+export async function createJob(range: DateRange, matchers: string[] = [], format: DumpFormat = 'tsdb', anonymization: Anonymization = '', delivery?: DumpDelivery) {
     return Client4.doFetch(`${Client4.getUrl()}/plugins/${manifest.id}/jobs/create`, {
         method: 'post',
         headers: {'Content-Type': 'application/json'},
-        body: JSON.stringify({min_t: range.from?.getTime(), max_t: range.to?.getTime()}),
+        body: JSON.stringify({min_t: range.from?.getTime(), max_t: range.to?.getTime(), matchers, format, anonymization, delivery}),
     });
 }
 
@@ -36,16 +60,85 @@ export function deleteJob(id: string) {
     });
 }
 
+export function cancelJob(id: string) {
+    return Client4.doFetch(`${Client4.getUrl()}/plugins/${manifest.id}/jobs/cancel/${id}`, {
+        method: 'post',
+    });
+}
+
+export function retryJob(id: string) {
+    return Client4.doFetch<Job>(`${Client4.getUrl()}/plugins/${manifest.id}/jobs/retry/${id}`, {
+        method: 'post',
+    });
+}
+
+export function verifyJob(id: string) {
+    return Client4.doFetch<ManifestVerification>(
+        `${Client4.getUrl()}/plugins/${manifest.id}/jobs/verify/${id}`,
+        {method: 'get'},
+    );
+}
+
 export async function deleteAllJobs() {
     return Client4.doFetch(`${Client4.getUrl()}/plugins/${manifest.id}/jobs/deleteAll`, {
         method: 'delete',
     });
 }
 
+export async function uploadImport(file: File) {
+    return Client4.doFetch<Job>(`${Client4.getUrl()}/plugins/${manifest.id}/imports/upload`, {
+        method: 'post',
+        headers: {'Content-Type': 'application/gzip'},
+        body: file,
+    });
+}
+
+export function getSchedules() {
+    return Client4.doFetch<DumpSchedule[]>(
+        `${Client4.getUrl()}/plugins/${manifest.id}/schedules`,
+        {method: 'get'},
+    );
+}
+
+export async function createSchedule(schedule: Pick<DumpSchedule, 'hour' | 'minute' | 'interval_hours' | 'range_hours' | 'keep'>) {
+    return Client4.doFetch<DumpSchedule>(`${Client4.getUrl()}/plugins/${manifest.id}/schedules/create`, {
+        method: 'post',
+        headers: {'Content-Type': 'application/json'},
+        body: JSON.stringify(schedule),
+    });
+}
+
+export function deleteSchedule(id: string) {
+    return Client4.doFetch(`${Client4.getUrl()}/plugins/${manifest.id}/schedules/delete/${id}`, {
+        method: 'delete',
+    });
+}
+
 export async function downloadJob(id: string) {
     const res = await fetch(`${Client4.getUrl()}/plugins/${manifest.id}/jobs/download/${id}`, {
         method: 'get',
     });
+    await saveResponse(res);
+}
+
+export async function exportMetrics(format: 'csv' | 'parquet', range: DateRange, matchers: string[], importId?: string) {
+    const params = new URLSearchParams({
+        format,
+        min_t: String(range.from?.getTime() ?? 0),
+        max_t: String(range.to?.getTime() ?? Date.now()),
+    });
+    matchers.forEach((m) => params.append('match', m));
+    if (importId) {
+        params.append('import_id', importId);
+    }
+
+    const res = await fetch(`${Client4.getUrl()}/plugins/${manifest.id}/tsdb/export?${params.toString()}`, {
+        method: 'get',
+    });
+    await saveResponse(res);
+}
+
+async function saveResponse(res: Response) {
     const blob = await res.blob();
     const href = window.URL.createObjectURL(blob);
     const link = document.createElement('a');
@@ -3,9 +3,25 @@
 
 import React, {ReactElement} from 'react';
 
-import type {Job} from '../types/types';
+import type {Job, JobProgress} from '../types/types';
 
-const JobDownloadLink = React.memo(({job, download, remove}: {job: Job, download: (id: string) => {}, remove: (id: string) => void}): ReactElement => {
+const formatProgress = (progress?: JobProgress) => {
+    if (!progress) {
+        return '';
+    }
+
+    let text = ` (${progress.phase}`;
+    if (progress.blocks_total > 0) {
+        text += `, ${progress.blocks_fetched}/${progress.blocks_total} blocks`;
+    }
+    if (progress.bytes_written > 0) {
+        text += `, ${(progress.bytes_written / (1024 * 1024)).toFixed(1)} MiB`;
+    }
+
+    return text + ')';
+};
+
+const JobDownloadLink = React.memo(({job, download, remove, cancel, retry}: {job: Job, download: (id: string) => {}, remove: (id: string) => void, cancel: (id: string) => void, retry: (id: string) => void}): ReactElement => {
     switch (job.status) {
     case 'success':
         return (
@@ -33,7 +49,14 @@ const JobDownloadLink = React.memo(({job, download, remove}: {job: Job, download
                 key={job.id}
                 style={{color: 'orange'}}
             >
-                {'Scheduled'}
+                {'Scheduled, '}
+                <a
+                    onClick={() => {
+                        cancel(job.id);
+                    }}
+                >
+                    {'Cancel'}
+                </a>
             </div>
         );
     case 'in_progress':
@@ -42,19 +65,66 @@ const JobDownloadLink = React.memo(({job, download, remove}: {job: Job, download
                 key={job.id}
                 style={{color: 'green'}}
             >
-                {'In progress..'}
+                {'In progress' + formatProgress(job.progress) + ', '}
+                <a
+                    onClick={() => {
+                        cancel(job.id);
+                    }}
+                >
+                    {'Cancel'}
+                </a>
             </div>
         );
     case 'error':
         return (
-            <a
+            <div
+                key={job.id}
                 style={{color: 'red'}}
-                onClick={() => {
-                    remove(job.id);
-                }}
+                title={job.error}
+            >
+                {job.failed_phase ? `Failed (${job.failed_phase}): ` : 'Failed: '}
+                {job.error || 'unknown error'}
+                {', '}
+                <a
+                    onClick={() => {
+                        retry(job.id);
+                    }}
+                >
+                    {'Retry'}
+                </a>
+                {', '}
+                <a
+                    onClick={() => {
+                        remove(job.id);
+                    }}
+                >
+                    {'Remove'}
+                </a>
+            </div>
+        );
+    case 'canceled':
+        return (
+            <div
+                key={job.id}
+                style={{color: 'gray'}}
             >
-                {'Remove (failed)'}
-            </a>
+                {'Canceled, '}
+                <a
+                    onClick={() => {
+                        retry(job.id);
+                    }}
+                >
+                    {'Retry'}
+                </a>
+                {', '}
+                <a
+                    onClick={() => {
+                        remove(job.id);
+                    }}
+                >
+                    {'Remove'}
+                </a>
+            </div>
         );
     default:
         return <>{'--'}</>;
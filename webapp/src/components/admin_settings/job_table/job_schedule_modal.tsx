@@ -9,12 +9,14 @@ import {DateRange, DayPicker} from 'react-day-picker';
 
 import 'react-day-picker/dist/style.css';
 
+import {Anonymization, DeliveryType, DumpDelivery, DumpFormat} from '../types/types';
+
 export type Props = {
     show: boolean;
     min_t?: number;
     max_t?: number;
     onClose: () => void;
-    onSubmit: (range: DateRange) => void;
+    onSubmit: (range: DateRange, matchers: string[], format: DumpFormat, anonymization: Anonymization, delivery?: DumpDelivery) => void;
 }
 
 const styles = {
@@ -49,6 +51,22 @@ const JobScheduleModal = ({show, min_t, max_t, onClose, onSubmit}: Props) => {
     }
 
     const [range, setRange] = useState<DateRange | undefined>(defaultSelected);
+    const [matchers, setMatchers] = useState('');
+    const [format, setFormat] = useState<DumpFormat>('tsdb');
+    const [anonymization, setAnonymization] = useState<Anonymization>('');
+    const [deliveryType, setDeliveryType] = useState<DeliveryType | ''>('');
+    const [channelId, setChannelId] = useState('');
+
+    const delivery = (): DumpDelivery | undefined => {
+        switch (deliveryType) {
+        case 'channel':
+            return {type: 'channel', channel_id: channelId.trim()};
+        case 'webhook':
+            return {type: 'webhook'};
+        default:
+            return undefined;
+        }
+    };
     return (
         <Modal
             dialogClassName='a11y__modal metrics-modal-schedule'
@@ -71,13 +89,53 @@ const JobScheduleModal = ({show, min_t, max_t, onClose, onSubmit}: Props) => {
                         selected={range}
                         onSelect={setRange}
                     />
+                    <textarea
+                        className='form-control'
+                        placeholder={'Optional series selectors, one per line, e.g. {job="calls"}'}
+                        value={matchers}
+                        onChange={(e) => setMatchers(e.target.value)}
+                    />
+                    <select
+                        className='form-control'
+                        value={format}
+                        onChange={(e) => setFormat(e.target.value as DumpFormat)}
+                    >
+                        <option value='tsdb'>{'TSDB blocks'}</option>
+                        <option value='openmetrics'>{'OpenMetrics text'}</option>
+                    </select>
+                    <select
+                        className='form-control'
+                        value={anonymization}
+                        onChange={(e) => setAnonymization(e.target.value as Anonymization)}
+                    >
+                        <option value=''>{'Keep label values'}</option>
+                        <option value='hash'>{'Hash sensitive label values'}</option>
+                        <option value='redact'>{'Redact sensitive label values'}</option>
+                    </select>
+                    <select
+                        className='form-control'
+                        value={deliveryType}
+                        onChange={(e) => setDeliveryType(e.target.value as DeliveryType | '')}
+                    >
+                        <option value=''>{'Keep in the file store only'}</option>
+                        <option value='channel'>{'Upload to a channel'}</option>
+                        <option value='webhook'>{'Post to the configured webhook'}</option>
+                    </select>
+                    {deliveryType === 'channel' &&
+                        <input
+                            className='form-control'
+                            placeholder={'Channel ID'}
+                            value={channelId}
+                            onChange={(e) => setChannelId(e.target.value)}
+                        />
+                    }
                     <div
                         className='col-sm-13'
                         style={styles.buttonRow}
                     >
                         <a
                             className='btn btn-primary'
-                            onClick={() => onSubmit(range!)}
+                            onClick={() => onSubmit(range!, matchers.split('\n').map((m) => m.trim()).filter((m) => m !== ''), format, anonymization, delivery())}
                         >
                             {'Submit'}
                         </a>
@@ -6,9 +6,9 @@ import classNames from 'classnames';
 
 import {DateRange} from 'react-day-picker';
 
-import {Job, TSDBStats} from '../types/types';
+import {Anonymization, DumpDelivery, DumpFormat, Job, JobStatus, TSDBStats} from '../types/types';
 
-import {createJob, deleteAllJobs, deleteJob, downloadJob, getJobs, getTSDBStats} from '../actions/actions';
+import {cancelJob, createJob, deleteAllJobs, deleteJob, downloadJob, getJobs, getTSDBStats, retryJob} from '../actions/actions';
 
 import DateTimeFormatter from '../utils/date_time';
 
@@ -17,6 +17,8 @@ import JobScheduleModal from './job_schedule_modal';
 import JobRemoveModal from './job_remove_modal';
 import './job_schedule_modal.scss';
 
+const jobsPerPage = 20;
+
 export type Props = {
     stats?: TSDBStats
     jobs: Job[];
@@ -28,6 +30,9 @@ export type Props = {
 type State = {
     stats?: TSDBStats
     jobs: Job[];
+    total: number;
+    page: number;
+    status?: JobStatus;
     showScheduleModal: boolean;
     showRemoveModal: boolean;
     className?: string;
@@ -42,17 +47,17 @@ export default class JobTable extends React.Component<State, Props> {
 
     constructor(props: Props) {
         super(props);
-        this.state = {jobs: [], showScheduleModal: false, showRemoveModal: false};
+        this.state = {jobs: [], total: 0, page: 0, showScheduleModal: false, showRemoveModal: false};
     }
 
     interval: ReturnType<typeof setInterval>|null = null;
 
     async componentDidMount() {
-        const jobs = await getJobs();
+        const list = await getJobs({per_page: jobsPerPage});
         const stats = await getTSDBStats();
 
         // eslint-disable-next-line react/no-did-mount-set-state
-        this.setState({jobs, stats});
+        this.setState({jobs: list.jobs, total: list.total, stats});
         this.interval = setInterval(this.reload, 15000);
     }
 
@@ -63,12 +68,24 @@ export default class JobTable extends React.Component<State, Props> {
     }
 
     reload = async () => {
-        const jobs = await getJobs();
-        this.setState({jobs});
+        const list = await getJobs({
+            status: this.state.status ? [this.state.status] : [],
+            page: this.state.page,
+            per_page: jobsPerPage,
+        });
+        this.setState({jobs: list.jobs, total: list.total});
+    };
+
+    setPage = (page: number) => {
+        this.setState({page}, this.reload);
+    };
+
+    setStatus = (status?: JobStatus) => {
+        this.setState({status, page: 0}, this.reload);
     };
 
     render() {
-        const createDump = (range: DateRange) => {
+        const createDump = (range: DateRange, matchers: string[], format: DumpFormat, anonymization: Anonymization, delivery?: DumpDelivery) => {
             if (range.to) {
                 // we need to manipulate one more day to the upper limit because the DayPicker
                 // returns the 12:00 AM timestamp of the selected range.
@@ -79,7 +96,7 @@ export default class JobTable extends React.Component<State, Props> {
                 range.to = new Date(range.from!.getTime() + (1000 * 60 * 60 * 24));
             }
 
-            createJob(range).finally(() => {
+            createJob(range, matchers, format, anonymization, delivery).finally(() => {
                 this.reload();
                 this.setState({showScheduleModal: false});
             });
@@ -95,6 +112,18 @@ export default class JobTable extends React.Component<State, Props> {
             });
         };
 
+        const stopJob = (id: string) => {
+            cancelJob(id).finally(() => {
+                this.reload();
+            });
+        };
+
+        const rerunJob = (id: string) => {
+            retryJob(id).finally(() => {
+                this.reload();
+            });
+        };
+
         const deleteAll = () => {
             deleteAllJobs().finally(() => {
                 this.reload();
@@ -136,6 +165,8 @@ export default class JobTable extends React.Component<State, Props> {
                             job={job}
                             download={downloadDump}
                             remove={removeJob}
+                            cancel={stopJob}
+                            retry={rerunJob}
                         />
                     </td>
                 </tr>
@@ -163,6 +194,18 @@ export default class JobTable extends React.Component<State, Props> {
                         onSubmit={createDump}
                     />
                     {removeButton()}
+                    <select
+                        className='form-control job-table__status'
+                        value={this.state.status || ''}
+                        onChange={(e) => this.setStatus((e.target.value || undefined) as JobStatus | undefined)}
+                    >
+                        <option value=''>{'All jobs'}</option>
+                        <option value='pending'>{'Scheduled'}</option>
+                        <option value='in_progress'>{'In progress'}</option>
+                        <option value='success'>{'Succeeded'}</option>
+                        <option value='error'>{'Failed'}</option>
+                        <option value='canceled'>{'Canceled'}</option>
+                    </select>
                     <JobRemoveModal
                         show={this.state.showRemoveModal}
                         onClose={() => this.setState({showRemoveModal: false})}
@@ -195,6 +238,25 @@ export default class JobTable extends React.Component<State, Props> {
                                 {items}
                             </tbody>
                         </table>
+                        {this.state.total > jobsPerPage &&
+                            <div className='job-table__pagination'>
+                                <a
+                                    className={classNames('btn', 'btn-link', {disabled: this.state.page === 0})}
+                                    onClick={() => this.state.page > 0 && this.setPage(this.state.page - 1)}
+                                >
+                                    {'Previous'}
+                                </a>
+                                <span>
+                                    {`${(this.state.page * jobsPerPage) + 1} - ${Math.min((this.state.page + 1) * jobsPerPage, this.state.total)} of ${this.state.total}`}
+                                </span>
+                                <a
+                                    className={classNames('btn', 'btn-link', {disabled: (this.state.page + 1) * jobsPerPage >= this.state.total})}
+                                    onClick={() => (this.state.page + 1) * jobsPerPage < this.state.total && this.setPage(this.state.page + 1)}
+                                >
+                                    {'Next'}
+                                </a>
+                            </div>
+                        }
                     </div>
                 }
             </div>
@@ -4,8 +4,8 @@
 import React from 'react';
 
 import DateTimeFormatter from '../utils/date_time';
-import {getTSDBStats} from '../actions/actions';
-import {TSDBStats} from '../types/types';
+import {getClusterStatus, getTSDBStats} from '../actions/actions';
+import {ClusterStatus, TSDBStats} from '../types/types';
 
 import './tsdb_stats.scss';
 
@@ -13,6 +13,7 @@ export type Props = {}
 
 type State = {
     stats: TSDBStats
+    cluster?: ClusterStatus
 }
 
 class TSDBStatsTable extends React.PureComponent<Props, State> {
@@ -30,9 +31,10 @@ class TSDBStatsTable extends React.PureComponent<Props, State> {
 
     async componentDidMount() {
         const stats = await getTSDBStats();
+        const cluster = await getClusterStatus();
 
         // eslint-disable-next-line react/no-did-mount-set-state
-        this.setState({stats});
+        this.setState({stats, cluster});
     }
 
     render() {
@@ -81,6 +83,24 @@ class TSDBStatsTable extends React.PureComponent<Props, State> {
                                     <td className='whitespace--nowrap'>{'Most recent timestamp'}</td>
                                     <td className='whitespace--nowrap'><DateTimeFormatter millis={this.state.stats.max_t}/></td>
                                 </tr>
+                                <tr
+                                    key={'collecting_node'}
+                                >
+                                    <td className='whitespace--nowrap'>{'Collecting node'}</td>
+                                    <td className='whitespace--nowrap'>{this.state.cluster?.leader?.hostname ?? '-'}</td>
+                                </tr>
+                                <tr
+                                    key={'leader_since'}
+                                >
+                                    <td className='whitespace--nowrap'>{'Collecting since'}</td>
+                                    <td className='whitespace--nowrap'><DateTimeFormatter millis={this.state.cluster?.leader?.leader_since ?? 0}/></td>
+                                </tr>
+                                <tr
+                                    key={'last_target_sync'}
+                                >
+                                    <td className='whitespace--nowrap'>{'Last target sync'}</td>
+                                    <td className='whitespace--nowrap'><DateTimeFormatter millis={this.state.cluster?.leader?.last_target_sync_at ?? 0}/></td>
+                                </tr>
                             </tbody>
                         </table>
                     </div>
@@ -1,7 +1,33 @@
 // Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
 // See LICENSE.txt for license information.
 
-export type JobStatus = 'pending' | 'in_progress' | 'error' | 'success';
+export type JobStatus = 'pending' | 'in_progress' | 'error' | 'success' | 'canceled';
+
+export type DumpFormat = 'tsdb' | 'openmetrics';
+
+export type JobType = 'dump' | 'import';
+
+export type Anonymization = '' | 'hash' | 'redact';
+
+export type DeliveryType = 'channel' | 'webhook';
+
+export type DumpDelivery = {
+    type: DeliveryType;
+    channel_id?: string;
+    user_id?: string;
+    delivered_at?: number;
+    error?: string;
+};
+
+export type JobPhase = 'fetch' | 'compact' | 'compress' | 'upload';
+
+export type JobProgress = {
+    phase: JobPhase;
+    blocks_fetched: number;
+    blocks_total: number;
+    bytes_written: number;
+    update_at: number;
+};
 
 export type Job = {
     id: string;
@@ -10,6 +36,54 @@ export type Job = {
     min_t: number;
     max_t: number;
     dump_location: string;
+    schedule_id?: string;
+    matchers?: string[];
+    format?: DumpFormat;
+    type?: JobType;
+    checksum?: string;
+    size?: number;
+    resolution?: number;
+    creator_id?: string;
+    delivery?: DumpDelivery;
+    encrypted?: boolean;
+    anonymization?: Anonymization;
+    progress?: JobProgress;
+    start_at?: number;
+    error?: string;
+    failed_phase?: JobPhase;
+};
+
+export type JobList = {
+    jobs: Job[];
+    total: number;
+};
+
+export type JobQuery = {
+    status?: JobStatus[];
+    created_after?: number;
+    created_before?: number;
+    page?: number;
+    per_page?: number;
+};
+
+export type ManifestVerification = {
+    valid: boolean;
+    error?: string;
+    checksum: string;
+    missing?: string[];
+    mismatched?: string[];
+    unexpected?: string[];
+};
+
+export type DumpSchedule = {
+    id: string;
+    create_at: number;
+    hour: number;
+    minute: number;
+    interval_hours: number;
+    range_hours: number;
+    keep: number;
+    last_run_at: number;
 };
 
 export type TSDBStats = {
@@ -18,3 +92,22 @@ export type TSDBStats = {
     num_series: number;
     num_samples: number;
 }
+
+export type ClusterNode = {
+    id: string;
+    hostname: string;
+}
+
+export type LeaderStatus = {
+    hostname: string;
+    leader_since: number;
+    topology: ClusterNode[];
+    last_target_sync_at: number;
+}
+
+export type ClusterStatus = {
+    ha: boolean;
+    hostname: string;
+    is_leader: boolean;
+    leader: LeaderStatus | null;
+}